@@ -34,6 +34,9 @@ type SettingModel struct {
 	BaseUrl                       string `form:"baseUrl" json:"baseUrl" query:"baseUrl"`
 	MaxDownloadConcurrency        int    `form:"maxDownloadConcurrency" json:"maxDownloadConcurrency" query:"maxDownloadConcurrency"`
 	UserAgent                     string `form:"userAgent" json:"userAgent" query:"userAgent"`
+	PreferredCodec                string `form:"preferredCodec" json:"preferredCodec" query:"preferredCodec"`
+	MaxBitrate                    int    `form:"maxBitrate" json:"maxBitrate" query:"maxBitrate"`
+	PreferredMediaKind            string `form:"preferredMediaKind" json:"preferredMediaKind" query:"preferredMediaKind"`
 }
 
 var searchOptions = map[string]string{
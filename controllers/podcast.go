@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path"
 	"strings"
@@ -51,8 +52,37 @@ type PatchPodcastItem struct {
 	Title    string `form:"title" json:"title" query:"title"`
 }
 
+type PatchPodcast struct {
+	RefreshInterval *int                   `json:"refreshInterval" form:"refreshInterval" query:"refreshInterval"`
+	Private         *bool                  `json:"private" form:"private" query:"private"`
+	Credentials     *model.FeedCredentials `json:"credentials"`
+	// Enclosure preferences are set together, empty values fall back to
+	// the settings.
+	EnclosurePreferences *EnclosurePreferences `json:"enclosurePreferences"`
+}
+
+type EnclosurePreferences struct {
+	PreferredCodec     string `json:"preferredCodec"`
+	MaxBitrate         int    `json:"maxBitrate"`
+	PreferredMediaKind string `json:"preferredMediaKind"`
+}
+
 type AddPodcastData struct {
-	Url string `binding:"required" form:"url" json:"url"`
+	Url         string                 `binding:"required" form:"url" json:"url"`
+	Private     bool                   `form:"private" json:"private"`
+	Credentials *model.FeedCredentials `json:"credentials"`
+}
+type AddLocalPodcastData struct {
+	Folder  string `binding:"required" form:"folder" json:"folder"`
+	Title   string `form:"title" json:"title"`
+	Summary string `form:"summary" json:"summary"`
+}
+type UploadPodcastItemData struct {
+	PodcastID    string `form:"podcastId"`
+	PodcastTitle string `form:"podcastTitle"`
+	Title        string `form:"title"`
+	PubDate      string `form:"pubDate"`
+	Notes        string `form:"notes"`
 }
 type AddTagData struct {
 	Label       string `binding:"required" form:"label" json:"label"`
@@ -106,6 +136,108 @@ func GetPodcastById(c *gin.Context) {
 	}
 }
 
+func PatchPodcastById(c *gin.Context) {
+	var searchByIdQuery SearchByIdQuery
+	if c.ShouldBindUri(&searchByIdQuery) == nil {
+
+		var input PatchPodcast
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if input.RefreshInterval != nil {
+			err := service.SetPodcastRefreshInterval(searchByIdQuery.Id, *input.RefreshInterval)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if input.Private != nil {
+			err := db.UpdatePodcastIsPrivate(searchByIdQuery.Id, *input.Private)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if input.Credentials != nil {
+			err := service.SetPodcastCredentials(searchByIdQuery.Id, input.Credentials)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if preferences := input.EnclosurePreferences; preferences != nil {
+			err := service.SetPodcastEnclosurePreferences(searchByIdQuery.Id, preferences.PreferredCodec, preferences.MaxBitrate, preferences.PreferredMediaKind)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		var podcast db.Podcast
+		err := db.GetPodcastById(searchByIdQuery.Id, &podcast)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		c.JSON(200, podcast)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	}
+}
+
+func GetPodcastMetadataChangesById(c *gin.Context) {
+	var searchByIdQuery SearchByIdQuery
+	if c.ShouldBindUri(&searchByIdQuery) == nil {
+		changes, err := db.GetPodcastMetadataChanges(searchByIdQuery.Id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		c.JSON(200, changes)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	}
+}
+
+func GetSuspectedDuplicatesByPodcastId(c *gin.Context) {
+	var searchByIdQuery SearchByIdQuery
+	if c.ShouldBindUri(&searchByIdQuery) == nil {
+		duplicates, err := service.GetSuspectedDuplicates(searchByIdQuery.Id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		c.JSON(200, duplicates)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	}
+}
+
+func GetSuspectedDuplicates(c *gin.Context) {
+	duplicates, err := service.GetSuspectedDuplicates("")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	c.JSON(200, duplicates)
+}
+
+func BackfillPodcastArchiveById(c *gin.Context) {
+	var searchByIdQuery SearchByIdQuery
+	if c.ShouldBindUri(&searchByIdQuery) == nil {
+		err := service.BackfillPodcastArchive(searchByIdQuery.Id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(200, gin.H{})
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	}
+}
+
 func PausePodcastById(c *gin.Context) {
 	var searchByIdQuery SearchByIdQuery
 	if c.ShouldBindUri(&searchByIdQuery) == nil {
@@ -285,6 +417,10 @@ func GetPodcastItemImageById(c *gin.Context) {
 		err := db.GetPodcastItemById(searchByIdQuery.Id, &podcast)
 		if err == nil {
 			if _, err = os.Stat(podcast.LocalImage); os.IsNotExist(err) {
+				if podcast.Image == "" {
+					c.Redirect(302, "/podcasts/"+podcast.PodcastID+"/image")
+					return
+				}
 				c.Redirect(302, podcast.Image)
 			} else {
 				c.File(podcast.LocalImage)
@@ -304,6 +440,19 @@ func GetPodcastImageById(c *gin.Context) {
 
 		err := db.GetPodcastById(searchByIdQuery.Id, &podcast)
 		if err == nil {
+			if podcast.LocalFolder != "" {
+				if localPath := service.GetLocalPodcastImagePath(&podcast); localPath != "" {
+					c.File(localPath)
+				} else {
+					c.Redirect(302, "/webassets/blank.png")
+				}
+				return
+			}
+
+			if podcast.Image == "" {
+				c.Redirect(302, "/webassets/blank.png")
+				return
+			}
 
 			localPath, err := service.GetPodcastLocalImagePath(podcast.Image, podcast.Title)
 			if err != nil {
@@ -332,10 +481,16 @@ func GetPodcastItemFileById(c *gin.Context) {
 		err := db.GetPodcastItemById(searchByIdQuery.Id, &podcast)
 		if err == nil {
 			if _, err = os.Stat(podcast.DownloadPath); !os.IsNotExist(err) {
+				// Videos are shown inline so that browsers play them, the
+				// download links of the pages still save them.
+				disposition := "attachment"
+				if service.IsVideo(&podcast) {
+					disposition = "inline"
+				}
 				c.Header("Content-Description", "File Transfer")
 				c.Header("Content-Transfer-Encoding", "binary")
-				c.Header("Content-Disposition", "attachment; filename="+path.Base(podcast.DownloadPath))
-				c.Header("Content-Type", GetFileContentType(podcast.DownloadPath))
+				c.Header("Content-Disposition", disposition+"; filename="+path.Base(podcast.DownloadPath))
+				c.Header("Content-Type", service.GetMediaType(&podcast))
 				c.File(podcast.DownloadPath)
 			} else {
 				c.Redirect(302, podcast.FileURL)
@@ -346,17 +501,55 @@ func GetPodcastItemFileById(c *gin.Context) {
 	}
 }
 
-func GetFileContentType(filePath string) string {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "application/octet-stream"
+func GetPodcastItemTranscriptById(c *gin.Context) {
+	var searchByIdQuery SearchByIdQuery
+
+	if c.ShouldBindUri(&searchByIdQuery) == nil {
+
+		var podcast db.PodcastItem
+
+		err := db.GetPodcastItemById(searchByIdQuery.Id, &podcast)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Podcast item not found"})
+			return
+		}
+
+		transcript := service.GetPreferredTranscript(podcast.Transcripts, c.Query("type"))
+		if transcript == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transcript not found"})
+			return
+		}
+
+		if _, err = os.Stat(transcript.DownloadPath); transcript.DownloadPath != "" && !os.IsNotExist(err) {
+			c.Header("Content-Type", service.GetTranscriptContentType(transcript.Type))
+			c.Header("X-Content-Type-Options", "nosniff")
+			c.File(transcript.DownloadPath)
+		} else {
+			c.Redirect(302, transcript.URL)
+		}
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 	}
-	defer file.Close()
-	buffer := make([]byte, 512)
-	if _, err := file.Read(buffer); err != nil {
-		return "application/octet-stream"
+}
+
+func GetPodcastItemChaptersById(c *gin.Context) {
+	var searchByIdQuery SearchByIdQuery
+
+	if c.ShouldBindUri(&searchByIdQuery) == nil {
+
+		var podcast db.PodcastItem
+
+		err := db.GetPodcastItemById(searchByIdQuery.Id, &podcast)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Podcast item not found"})
+			return
+		}
+
+		c.Header("Content-Type", "application/json+chapters")
+		c.JSON(200, service.GetChaptersJson(&podcast))
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 	}
-	return http.DetectContentType(buffer)
 }
 
 func MarkPodcastItemAsUnplayed(c *gin.Context) {
@@ -427,12 +620,22 @@ func DownloadPodcastItem(c *gin.Context) {
 	var searchByIdQuery SearchByIdQuery
 
 	if c.ShouldBindUri(&searchByIdQuery) == nil {
-		go service.DownloadSingleEpisode(searchByIdQuery.Id)
+		err := service.DownloadSingleEpisode(searchByIdQuery.Id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(200, gin.H{})
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 	}
 }
+func GetDownloads(c *gin.Context) {
+	c.JSON(200, service.GetDownloadQueueState())
+}
+func GetDownloadProgress(c *gin.Context) {
+	c.JSON(200, service.GetActiveDownloads())
+}
 func DeletePodcastItem(c *gin.Context) {
 	var searchByIdQuery SearchByIdQuery
 
@@ -454,7 +657,7 @@ func AddPodcast(c *gin.Context) {
 		return
 	}
 
-	pod, err := service.AddPodcast(addPodcastData.Url)
+	pod, err := service.AddPodcast(addPodcastData.Url, addPodcastData.Private, addPodcastData.Credentials)
 	if err != nil {
 
 		var podcastAlreadyExistsErr *model.PodcastAlreadyExistsError
@@ -470,6 +673,57 @@ func AddPodcast(c *gin.Context) {
 	c.JSON(200, pod)
 }
 
+func AddLocalPodcast(c *gin.Context) {
+	var addLocalPodcastData AddLocalPodcastData
+	err := c.ShouldBindJSON(&addLocalPodcastData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request.", "err": err})
+		return
+	}
+
+	pod, err := service.AddLocalPodcast(addLocalPodcastData.Folder, addLocalPodcastData.Title, addLocalPodcastData.Summary)
+	if err != nil {
+		var podcastAlreadyExistsErr *model.PodcastAlreadyExistsError
+		if errors.As(err, &podcastAlreadyExistsErr) {
+			c.JSON(409, gin.H{"message": "A podcast already uses this folder.", "err": err})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	c.JSON(200, pod)
+}
+
+func UploadPodcastItem(c *gin.Context) {
+	var uploadData UploadPodcastItemData
+	err := c.ShouldBind(&uploadData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request.", "err": err})
+		return
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "A file is required."})
+		return
+	}
+	defer file.Close()
+
+	item, err := service.UploadEpisode(service.EpisodeUpload{
+		PodcastID:    uploadData.PodcastID,
+		PodcastTitle: uploadData.PodcastTitle,
+		Title:        uploadData.Title,
+		PubDate:      uploadData.PubDate,
+		Notes:        uploadData.Notes,
+		FileName:     header.Filename,
+		ContentType:  header.Header.Get("Content-Type"),
+	}, file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	c.JSON(200, item)
+}
+
 func GetAllTags(c *gin.Context) {
 	tags, err := db.GetAllTags("")
 	if err != nil {
@@ -508,6 +762,7 @@ func createRss(items []db.PodcastItem, title, description, image string, c *gin.
 		rssItem := model.RssItem{
 			Title:       item.Title,
 			Description: item.Summary,
+			Encoded:     item.ShowNotes,
 			Summary:     item.Summary,
 			Image: model.RssItemImage{
 				Text: item.Title,
@@ -517,7 +772,7 @@ func createRss(items []db.PodcastItem, title, description, image string, c *gin.
 			Enclosure: model.RssItemEnclosure{
 				URL:    fmt.Sprintf("%s/podcastitems/%s/file", url, item.ID),
 				Length: fmt.Sprint(item.FileSize),
-				Type:   "audio/mpeg",
+				Type:   service.GetMediaType(&item),
 			},
 			PubDate: item.PubDate.Format("Mon, 02 Jan 2006 15:04:05 -0700"),
 			Guid: model.RssItemGuid{
@@ -528,6 +783,21 @@ func createRss(items []db.PodcastItem, title, description, image string, c *gin.
 			Text:     item.Title,
 			Duration: fmt.Sprint(item.Duration),
 		}
+		for _, transcript := range item.Transcripts {
+			rssItem.Transcript = append(rssItem.Transcript, model.RssItemTranscript{
+				URL:      fmt.Sprintf("%s/podcastitems/%s/transcript?type=%s", url, item.ID, neturl.QueryEscape(transcript.Type)),
+				Type:     transcript.Type,
+				Language: transcript.Language,
+				Rel:      transcript.Rel,
+			})
+		}
+		if len(item.Chapters) > 0 {
+			rssItem.Chapters = &model.RssItemChapters{
+				URL:  fmt.Sprintf("%s/podcastitems/%s/chapters", url, item.ID),
+				Type: "application/json+chapters",
+			}
+			rssItem.PscChapters = service.GetPscChapters(&item)
+		}
 		rssItems = append(rssItems, rssItem)
 	}
 
@@ -543,6 +813,7 @@ func createRss(items []db.PodcastItem, title, description, image string, c *gin.
 		Atom:    "http://www.w3.org/2005/Atom",
 		Psc:     "https://podlove.org/simple-chapters/",
 		Content: "http://purl.org/rss/1.0/modules/content/",
+		Podcast: "https://podcastindex.org/namespace/1.0",
 		Channel: model.RssChannel{
 			Item:        rssItems,
 			Title:       title,
@@ -580,8 +851,12 @@ func GetRssForPodcastById(c *gin.Context) {
 
 	description := podcast.Summary
 	title := podcast.Title
+	image := podcast.Image
+	if image == "" {
+		image = fmt.Sprintf("%s/podcasts/%s/image", getBaseUrl(c), podcast.ID)
+	}
 
-	c.XML(200, createRss(*items, title, description, podcast.Image, c))
+	c.XML(200, createRss(*items, title, description, image, c))
 }
 
 func GetRssForTagById(c *gin.Context) {
@@ -711,6 +986,7 @@ func UpdateSetting(c *gin.Context) {
 			settingModel.AutoDownload, settingModel.AppendDateToFileName, settingModel.AppendEpisodeNumberToFileName,
 			settingModel.DarkMode, settingModel.DownloadEpisodeImages, settingModel.GenerateNFOFile, settingModel.DontDownloadDeletedFromDisk, settingModel.BaseUrl,
 			settingModel.MaxDownloadConcurrency, settingModel.UserAgent,
+			settingModel.PreferredCodec, settingModel.MaxBitrate, settingModel.PreferredMediaKind,
 		)
 		if err == nil {
 			c.JSON(200, gin.H{"message": "Success"})
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/akhilrex/podgrab/service"
 	"github.com/gorilla/websocket"
 )
 
@@ -16,6 +19,9 @@ const (
 	websocketMessageNoPlayer       = "NoPlayer"
 	websocketMessagePlayerExists   = "PlayerExists"
 	websocketMessageError          = "Error"
+	// DownloadProgress messages carry a service.DownloadJob, sent to every
+	// page whenever a download is queued, makes progress or finishes.
+	websocketMessageDownloadProgress = "DownloadProgress"
 )
 
 type EnqueuePayload struct {
@@ -29,11 +35,24 @@ var wsupgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
+// Connections of the players, by the identifier of the page that opened them,
+// and of every page. Both are guarded by connectionsLock.
 var activePlayers = make(map[*websocket.Conn]string)
 var allConnections = make(map[*websocket.Conn]string)
+var connectionsLock sync.Mutex
 
 var broadcast = make(chan Message) // broadcast channel
 
+// Latest progress message of every download not yet sent. Downloads only
+// signal progressReady without waiting, so a slow page never holds them up,
+// and progress they make meanwhile replaces the pending message.
+var pendingProgress = make(map[string]Message)
+var pendingProgressLock sync.Mutex
+var progressReady = make(chan struct{}, 1)
+
+// Time after which a write to a page which does not read is abandoned.
+const websocketWriteTimeout = 10 * time.Second
+
 type Message struct {
 	Identifier  string          `json:"identifier"`
 	MessageType string          `json:"messageType"`
@@ -54,42 +73,123 @@ func WSHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			//	fmt.Println("Socket Error")
 			// fmt.Println(err.Error())
+			connectionsLock.Lock()
 			isPlayer := activePlayers[conn] != ""
+			delete(activePlayers, conn)
+			delete(allConnections, conn)
+			connectionsLock.Unlock()
 			if isPlayer {
-				delete(activePlayers, conn)
 				broadcast <- Message{
 					MessageType: "PlayerRemoved",
 					Identifier:  mess.Identifier,
 				}
 			}
-			delete(allConnections, conn)
 			break
 		}
 		mess.Connection = conn
+		connectionsLock.Lock()
 		allConnections[conn] = mess.Identifier
+		connectionsLock.Unlock()
 		broadcast <- mess
 		//	conn.WriteJSON(mess)
 	}
 }
 
+// BroadcastDownloadProgress sends the state of a download to every page. It
+// never blocks: the message is sent by HandleWebsocketMessages, unless a
+// newer state of the same download replaces it first.
+func BroadcastDownloadProgress(job service.DownloadJob) {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	pendingProgressLock.Lock()
+	pendingProgress[job.PodcastItemID] = Message{
+		MessageType: websocketMessageDownloadProgress,
+		Payload:     string(payload),
+	}
+	pendingProgressLock.Unlock()
+	select {
+	case progressReady <- struct{}{}:
+	default:
+	}
+}
+
+// getConnections returns the connections of every page.
+func getConnections() []*websocket.Conn {
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+	connections := make([]*websocket.Conn, 0, len(allConnections))
+	for connection := range allConnections {
+		connections = append(connections, connection)
+	}
+	return connections
+}
+
+func getPlayer(identifier string) *websocket.Conn {
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+	for connection, id := range activePlayers {
+		if identifier == id {
+			return connection
+		}
+	}
+	return nil
+}
+
+// writeMessage sends a message to a page, giving up after
+// websocketWriteTimeout. Messages are only written from
+// HandleWebsocketMessages, as a connection supports a single writer.
+func writeMessage(connection *websocket.Conn, msg Message) {
+	connection.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	err := connection.WriteJSON(msg)
+	if err != nil {
+		// The page is gone or stuck, its reader sees the connection closed.
+		connection.Close()
+	}
+}
+
+func sendPendingProgress() {
+	pendingProgressLock.Lock()
+	messages := pendingProgress
+	pendingProgress = make(map[string]Message)
+	pendingProgressLock.Unlock()
+
+	connections := getConnections()
+	for _, msg := range messages {
+		for _, connection := range connections {
+			writeMessage(connection, msg)
+		}
+	}
+}
+
 func HandleWebsocketMessages() {
 	for {
 		// Grab the next message from the broadcast channel
-		msg := <-broadcast
+		var msg Message
+		select {
+		case msg = <-broadcast:
+		case <-progressReady:
+			sendPendingProgress()
+			continue
+		}
 
 		switch msg.MessageType {
 		case websocketMessageRegisterPlayer:
+			connectionsLock.Lock()
 			activePlayers[msg.Connection] = msg.Identifier
-			for connection := range allConnections {
-				connection.WriteJSON(Message{
+			connectionsLock.Unlock()
+			for _, connection := range getConnections() {
+				writeMessage(connection, Message{
 					Identifier:  msg.Identifier,
 					MessageType: websocketMessagePlayerExists,
 				})
 			}
 			fmt.Println("Player Registered")
 		case websocketMessagePlayerRemoved:
-			for connection := range allConnections {
-				connection.WriteJSON(Message{
+			for _, connection := range getConnections() {
+				writeMessage(connection, Message{
 					Identifier:  msg.Identifier,
 					MessageType: websocketMessageNoPlayer,
 				})
@@ -103,8 +203,8 @@ func HandleWebsocketMessages() {
 
 				items, err := getItemsToPlay(payload.ItemIds, payload.PodcastId, payload.TagIds)
 				if err != nil {
-					for connection := range allConnections {
-						connection.WriteJSON(Message{
+					for _, connection := range getConnections() {
+						writeMessage(connection, Message{
 							Identifier:  msg.Identifier,
 							MessageType: websocketMessageError,
 						})
@@ -112,18 +212,11 @@ func HandleWebsocketMessages() {
 					break
 				}
 
-				var player *websocket.Conn
-				for connection, id := range activePlayers {
-
-					if msg.Identifier == id {
-						player = connection
-						break
-					}
-				}
+				player := getPlayer(msg.Identifier)
 				if player != nil {
 					payloadStr, err := json.Marshal(items)
 					if err == nil {
-						player.WriteJSON(Message{
+						writeMessage(player, Message{
 							Identifier:  msg.Identifier,
 							MessageType: websocketMessageEnqueue,
 							Payload:     string(payloadStr),
@@ -134,22 +227,15 @@ func HandleWebsocketMessages() {
 				fmt.Println(err.Error())
 			}
 		case websocketMessageRegister:
-			var player *websocket.Conn
-			for connection, id := range activePlayers {
-
-				if msg.Identifier == id {
-					player = connection
-					break
-				}
-			}
+			player := getPlayer(msg.Identifier)
 
 			if player == nil {
-				msg.Connection.WriteJSON(Message{
+				writeMessage(msg.Connection, Message{
 					Identifier:  msg.Identifier,
 					MessageType: websocketMessageNoPlayer,
 				})
 			} else {
-				msg.Connection.WriteJSON(Message{
+				writeMessage(msg.Connection, Message{
 					Identifier:  msg.Identifier,
 					MessageType: websocketMessagePlayerExists,
 				})
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/akhilrex/podgrab/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Pushed feeds are ignored beyond this size, the feed is fetched again anyway.
+const maxWebSubNotificationSize = 10 << 20
+
+func VerifyWebSubSubscription(c *gin.Context) {
+	var searchByIdQuery SearchByIdQuery
+	if c.ShouldBindUri(&searchByIdQuery) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	challenge, err := service.VerifyWebSubSubscription(searchByIdQuery.Id,
+		c.Query("hub.mode"), c.Query("hub.topic"), c.Query("hub.challenge"), c.Query("hub.lease_seconds"))
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.String(http.StatusOK, challenge)
+}
+
+func ReceiveWebSubNotification(c *gin.Context) {
+	var searchByIdQuery SearchByIdQuery
+	if c.ShouldBindUri(&searchByIdQuery) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebSubNotificationSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	err = service.HandleWebSubNotification(searchByIdQuery.Id, body, c.GetHeader("X-Hub-Signature"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Tells the hub the subscription is gone.
+		c.Status(http.StatusGone)
+		return
+	}
+	if errors.Is(err, service.ErrWebSubNotAuthenticated) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		service.Logger.Errorw("Ignoring websub notification", err)
+	}
+	c.Status(http.StatusAccepted)
+}
@@ -35,7 +35,7 @@ func Init() (*gorm.DB, error) {
 
 // Migrate Database
 func Migrate() error {
-	err := DB.AutoMigrate(&Podcast{}, &PodcastItem{}, &Setting{}, &Migration{}, &JobLock{}, &Tag{})
+	err := DB.AutoMigrate(&Podcast{}, &PodcastItem{}, &Setting{}, &Migration{}, &JobLock{}, &Tag{}, &Transcript{}, &Chapter{}, &PodcastURL{}, &PodcastMetadataChange{}, &AlternateEnclosure{})
 	if err != nil {
 		return pkgErrors.Wrap(err, "failed to migrate database")
 	}
@@ -14,10 +14,27 @@ import (
 )
 
 func GetPodcastByURL(url string, podcast *Podcast) error {
-	result := DB.Preload(clause.Associations).Where(&Podcast{URL: url}).First(&podcast)
+	result := DB.Preload(clause.Associations).Where(&Podcast{URL: url}).Or("id in (select podcast_id from podcast_urls where url=?)", url).First(&podcast)
 	return result.Error
 }
 
+// UpdatePodcastURL points the podcast at its new feed location and records the
+// old one in its URL history.
+func UpdatePodcastURL(podcastId, oldURL, newURL string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("podcast_id=? and url=?", podcastId, newURL).Delete(&PodcastURL{})
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Create(&PodcastURL{PodcastID: podcastId, URL: oldURL})
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Model(Podcast{}).Where("id=?", podcastId).Update("url", newURL)
+		return result.Error
+	})
+}
+
 func GetAllPodcasts(podcasts *[]Podcast, sorting string) error {
 	if sorting == "" {
 		sorting = "created_at"
@@ -27,13 +44,15 @@ func GetAllPodcasts(podcasts *[]Podcast, sorting string) error {
 }
 
 func GetAllPodcastItems(podcasts *[]PodcastItem) error {
-	result := DB.Preload("Podcast").Order("pub_date desc").Find(&podcasts)
+	result := DB.Preload("Podcast").Preload("Transcripts").Preload("Chapters", func(db *gorm.DB) *gorm.DB {
+		return db.Order("start_time")
+	}).Order("pub_date desc").Find(&podcasts)
 	return result.Error
 }
 
 func GetAllPodcastItemsWithoutSize() (*[]PodcastItem, error) {
 	var podcasts []PodcastItem
-	result := DB.Where("file_size<=?", 0).Order("pub_date desc").Find(&podcasts)
+	result := DB.Preload("Podcast").Where("file_size<=?", 0).Order("pub_date desc").Find(&podcasts)
 	return &podcasts, result.Error
 }
 
@@ -66,6 +85,12 @@ func GetPaginatedPodcastItemsNew(queryModel model.EpisodesFilter) (*[]PodcastIte
 			}
 		}
 	}
+	if queryModel.DownloadStatus != nil {
+		downloadStatus, ok := ParseDownloadStatus(*queryModel.DownloadStatus)
+		if ok {
+			query = query.Where("download_status=?", downloadStatus)
+		}
+	}
 	if queryModel.IsPlayed != nil {
 		isPlayed, err := strconv.ParseBool(*queryModel.IsPlayed)
 		if err == nil {
@@ -77,6 +102,13 @@ func GetPaginatedPodcastItemsNew(queryModel model.EpisodesFilter) (*[]PodcastIte
 		}
 	}
 
+	if queryModel.IsRemovedUpstream != nil {
+		isRemovedUpstream, err := strconv.ParseBool(*queryModel.IsRemovedUpstream)
+		if err == nil {
+			query = query.Where("is_removed_upstream=?", isRemovedUpstream)
+		}
+	}
+
 	if queryModel.Q != "" {
 		query = query.Where("UPPER(title) like ?", "%"+strings.TrimSpace(strings.ToUpper(queryModel.Q))+"%")
 	}
@@ -131,6 +163,22 @@ func GetPaginatedTags(page int, count int, tags *[]Tag, total *int64) error {
 
 	return result.Error
 }
+func GetPodcastByLocalFolder(folder string, podcast *Podcast) error {
+	result := DB.Where("local_folder=?", folder).First(&podcast)
+	return result.Error
+}
+
+func GetCustomPodcastByTitle(title string, podcast *Podcast) error {
+	result := DB.Where("is_custom=? and title=?", true, title).First(&podcast)
+	return result.Error
+}
+
+func GetLocalPodcasts() (*[]Podcast, error) {
+	var podcasts []Podcast
+	result := DB.Where("local_folder!=?", "").Find(&podcasts)
+	return &podcasts, result.Error
+}
+
 func GetPodcastById(id string, podcast *Podcast) error {
 
 	result := DB.Preload("PodcastItems", func(db *gorm.DB) *gorm.DB {
@@ -146,12 +194,32 @@ func GetPodcastItemById(id string, podcastItem *PodcastItem) error {
 }
 func DeletePodcastItemById(id string) error {
 
-	result := DB.Where("id=?", id).Delete(&PodcastItem{})
+	result := DB.Where("podcast_item_id=?", id).Delete(&Transcript{})
+	if result.Error != nil {
+		return result.Error
+	}
+	result = DB.Where("podcast_item_id=?", id).Delete(&Chapter{})
+	if result.Error != nil {
+		return result.Error
+	}
+	result = DB.Where("podcast_item_id=?", id).Delete(&AlternateEnclosure{})
+	if result.Error != nil {
+		return result.Error
+	}
+	result = DB.Where("id=?", id).Delete(&PodcastItem{})
 	return result.Error
 }
 func DeletePodcastById(id string) error {
 
-	result := DB.Where("id=?", id).Delete(&Podcast{})
+	result := DB.Where("podcast_id=?", id).Delete(&PodcastURL{})
+	if result.Error != nil {
+		return result.Error
+	}
+	result = DB.Where("podcast_id=?", id).Delete(&PodcastMetadataChange{})
+	if result.Error != nil {
+		return result.Error
+	}
+	result = DB.Where("id=?", id).Delete(&Podcast{})
 	return result.Error
 }
 
@@ -189,7 +257,7 @@ func GetAllPodcastItemsByIds(podcastItemIds []string) (*[]PodcastItem, error) {
 }
 
 func SetAllEpisodesToDownload(podcastId string) error {
-	result := DB.Model(PodcastItem{}).Where(&PodcastItem{PodcastID: podcastId, DownloadStatus: Deleted}).Update("download_status", NotDownloaded)
+	result := DB.Model(PodcastItem{}).Where("podcast_id=? and download_status in ?", podcastId, []DownloadStatus{Deleted, Failed}).Updates(map[string]interface{}{"download_status": NotDownloaded, "download_attempts": 0, "next_download_retry": nil})
 	return result.Error
 }
 func UpdateLastEpisodeDateForPodcast(podcastId string, lastEpisode time.Time) error {
@@ -197,11 +265,187 @@ func UpdateLastEpisodeDateForPodcast(podcastId string, lastEpisode time.Time) er
 	return result.Error
 }
 
+// UpdatePodcastFeedCache stores the caching validators of the last successfully
+// processed feed so that the next refresh can be made conditional.
+func UpdatePodcastFeedCache(podcastId, etag, lastModified, feedHash string) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Updates(map[string]interface{}{
+		"e_tag":         etag,
+		"last_modified": lastModified,
+		"feed_hash":     feedHash,
+	})
+	return result.Error
+}
+
+func UpdatePodcastFeedHealth(podcast *Podcast) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcast.ID).Updates(map[string]interface{}{
+		"last_fetch_date":      podcast.LastFetchDate,
+		"last_fetch_status":    podcast.LastFetchStatus,
+		"last_fetch_error":     podcast.LastFetchError,
+		"consecutive_failures": podcast.ConsecutiveFailures,
+		"last_success_date":    podcast.LastSuccessDate,
+		"next_refresh_date":    podcast.NextRefreshDate,
+	})
+	return result.Error
+}
+
+func UpdatePodcastRefreshSchedule(podcastId string, isComplete bool, refreshInterval int) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Updates(map[string]interface{}{
+		"is_complete":      isComplete,
+		"refresh_interval": refreshInterval,
+	})
+	return result.Error
+}
+
+func UpdatePodcastRefreshIntervalOverride(podcastId string, refreshIntervalOverride int, nextRefreshDate *time.Time) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Updates(map[string]interface{}{
+		"refresh_interval_override": refreshIntervalOverride,
+		"next_refresh_date":         nextRefreshDate,
+	})
+	return result.Error
+}
+
+func UpdatePodcastHub(podcastId, hubURL, hubTopic, hubSecret string) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Updates(map[string]interface{}{
+		"hub_url":          hubURL,
+		"hub_topic":        hubTopic,
+		"hub_secret":       hubSecret,
+		"hub_lease_expiry": nil,
+		"hub_retry_date":   nil,
+	})
+	return result.Error
+}
+
+func UpdatePodcastHubLeaseExpiry(podcastId string, leaseExpiry *time.Time) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Update("hub_lease_expiry", leaseExpiry)
+	return result.Error
+}
+
+func UpdatePodcastHubRetryDate(podcastId string, retryDate *time.Time) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Update("hub_retry_date", retryDate)
+	return result.Error
+}
+
+// GetPodcastsWithHubLeaseExpiringBefore returns the podcasts with a hub whose
+// lease is missing or expires before date, and which may be subscribed again.
+func GetPodcastsWithHubLeaseExpiringBefore(date time.Time) (*[]Podcast, error) {
+	var podcasts []Podcast
+	result := DB.Where("hub_url!=?", "").
+		Where("hub_lease_expiry is null or hub_lease_expiry<?", date).
+		Where("hub_retry_date is null or hub_retry_date<?", time.Now()).
+		Find(&podcasts)
+	return &podcasts, result.Error
+}
+
+func UpdatePodcastCredentials(podcastId string, credentials string, hasCredentials bool) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Updates(map[string]interface{}{
+		"credentials":     credentials,
+		"has_credentials": hasCredentials,
+	})
+	return result.Error
+}
+
+func UpdatePodcastIsPrivate(podcastId string, isPrivate bool) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Update("is_private", isPrivate)
+	return result.Error
+}
+
+// UpdatePodcastItemsRemovedUpstream flags the items of the podcast published
+// since the given date whose GUID is not in guids anymore as removed upstream,
+// and clears the flag of the ones which came back. Older items are left alone,
+// as feeds which only carry their latest episodes do not tell about them. It
+// returns the number of items newly flagged.
+func UpdatePodcastItemsRemovedUpstream(podcastId string, guids []string, since time.Time, removedDate time.Time) (int64, error) {
+	var removed int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var missing []PodcastItem
+		result := tx.Select("id", "pub_date").
+			Where("podcast_id=? and is_removed_upstream=? and is_archived=? and guid not in ?", podcastId, false, false, guids).
+			Find(&missing)
+		if result.Error != nil {
+			return result.Error
+		}
+		// Dates are compared here rather than in the query as they are
+		// stored with the time zone of the feed.
+		var ids []string
+		for _, item := range missing {
+			if !item.PubDate.Before(since) {
+				ids = append(ids, item.ID)
+			}
+		}
+		if len(ids) > 0 {
+			result = tx.Model(PodcastItem{}).
+				Where("id in ?", ids).
+				Updates(map[string]interface{}{"is_removed_upstream": true, "removed_upstream_date": removedDate})
+			if result.Error != nil {
+				return result.Error
+			}
+			removed = result.RowsAffected
+		}
+		return tx.Model(PodcastItem{}).
+			Where("podcast_id=? and is_removed_upstream=? and guid in ?", podcastId, true, guids).
+			Updates(map[string]interface{}{"is_removed_upstream": false, "removed_upstream_date": nil}).Error
+	})
+	return removed, err
+}
+
+// UpdatePodcastMetadata saves the channel level metadata of the podcast along
+// with the log of what changed.
+func UpdatePodcastMetadata(podcast *Podcast, changes []PodcastMetadataChange) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(Podcast{}).Where("id=?", podcast.ID).Updates(map[string]interface{}{
+			"title":   podcast.Title,
+			"summary": podcast.Summary,
+			"author":  podcast.Author,
+			"image":   podcast.Image,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		return tx.Create(&changes).Error
+	})
+}
+
+func UpdatePodcastBackfill(podcast *Podcast) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcast.ID).Updates(map[string]interface{}{
+		"backfill_archive":       podcast.BackfillArchive,
+		"backfill_next_url":      podcast.BackfillNextURL,
+		"backfill_pages":         podcast.BackfillPages,
+		"backfill_complete_date": podcast.BackfillCompleteDate,
+	})
+	return result.Error
+}
+
+func GetPodcastsWithPendingBackfill() (*[]Podcast, error) {
+	var podcasts []Podcast
+	result := DB.Where("backfill_archive=? and backfill_complete_date is null", true).Find(&podcasts)
+	return &podcasts, result.Error
+}
+
+func GetPodcastMetadataChanges(podcastId string) (*[]PodcastMetadataChange, error) {
+	var changes []PodcastMetadataChange
+	result := DB.Where("podcast_id=?", podcastId).Order("created_at desc").Find(&changes)
+	return &changes, result.Error
+}
+
 func UpdatePodcastItemFileSize(podcastItemId string, size int64) error {
 	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Update("file_size", size)
 	return result.Error
 }
 
+func GetAllPodcastItemsWithoutDurationOrDate() (*[]PodcastItem, error) {
+	var podcastItems []PodcastItem
+	result := DB.Preload("Podcast").Where("duration<=? or pub_date=?", 0, time.Time{}).Order("podcast_id").Find(&podcastItems)
+	return &podcastItems, result.Error
+}
+
+func UpdatePodcastItemDurationAndPubDate(podcastItemId string, duration int, pubDate time.Time) error {
+	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Updates(map[string]interface{}{"duration": duration, "pub_date": pubDate})
+	return result.Error
+}
+
 func GetAllPodcastItemsWithoutImage() (*[]PodcastItem, error) {
 	var podcastItems []PodcastItem
 	result := DB.Preload(clause.Associations).Where("local_image is ?", nil).Where("image != ?", "").Where("download_status=?", Downloaded).Order("created_at desc").Find(&podcastItems)
@@ -209,9 +453,17 @@ func GetAllPodcastItemsWithoutImage() (*[]PodcastItem, error) {
 	return &podcastItems, result.Error
 }
 
+// GetAllPodcastItemsWithoutTranscripts returns the downloaded episodes with
+// transcripts which were not saved next to them.
+func GetAllPodcastItemsWithoutTranscripts() (*[]PodcastItem, error) {
+	var podcastItems []PodcastItem
+	result := DB.Where("download_status=?", Downloaded).Where("id in (select podcast_item_id from transcripts where download_path is null or download_path='')").Order("created_at desc").Find(&podcastItems)
+	return &podcastItems, result.Error
+}
+
 func GetAllPodcastItemsToBeDownloaded() (*[]PodcastItem, error) {
 	var podcastItems []PodcastItem
-	result := DB.Preload(clause.Associations).Where("download_status=?", NotDownloaded).Find(&podcastItems)
+	result := DB.Preload(clause.Associations).Where("download_status=? or (download_status=? and next_download_retry<=?)", NotDownloaded, Failed, time.Now()).Find(&podcastItems)
 	// fmt.Println("To be downloaded : " + string(len(podcastItems)))
 	return &podcastItems, result.Error
 }
@@ -239,6 +491,7 @@ func GetPodcastEpisodeDiskStats() (PodcastItemConsolidateDiskStatsModel, error)
 		Downloaded:      dict[Downloaded],
 		Downloading:     dict[Downloading],
 		Deleted:         dict[Deleted],
+		Failed:          dict[Failed],
 		NotDownloaded:   dict[NotDownloaded],
 		PendingDownload: dict[NotDownloaded] + dict[Downloading],
 	}
@@ -282,6 +535,64 @@ func GetPodcastItemsByPodcastIdAndGUIDs(podcastId string, guids []string) (*[]Po
 	return &podcastItems, result.Error
 }
 
+func GetPodcastItemsByPodcastIdNotInGUIDs(podcastId string, guids []string) (*[]PodcastItem, error) {
+	var podcastItems []PodcastItem
+	result := DB.Preload(clause.Associations).Where(&PodcastItem{PodcastID: podcastId}).Where("guid NOT IN ?", guids).Find(&podcastItems)
+	return &podcastItems, result.Error
+}
+
+func UpdatePodcastItemEnclosureType(podcastItemId string, enclosureType string) error {
+	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Update("enclosure_type", enclosureType)
+	return result.Error
+}
+
+func UpdatePodcastItemDownloadStatus(podcastItemId string, downloadStatus DownloadStatus) error {
+	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Update("download_status", downloadStatus)
+	return result.Error
+}
+
+// ResetInterruptedDownloads sets the episodes left downloading back to not
+// downloaded. Uploads, which have no enclosure, are left alone.
+func ResetInterruptedDownloads() error {
+	result := DB.Model(PodcastItem{}).Where("download_status=? and file_url!=''", Downloading).Update("download_status", NotDownloaded)
+	return result.Error
+}
+
+// UpdatePodcastItemDownloadFailure marks the episode as failed to download,
+// to be retried at nextRetry unless it is nil.
+func UpdatePodcastItemDownloadFailure(podcastItemId string, downloadError string, attempts int, nextRetry *time.Time) error {
+	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Updates(map[string]interface{}{"download_status": Failed, "download_error": downloadError, "download_attempts": attempts, "next_download_retry": nextRetry})
+	return result.Error
+}
+
+func UpdatePodcastItemEnclosureLength(podcastItemId string, enclosureLength int64) error {
+	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Update("enclosure_length", enclosureLength)
+	return result.Error
+}
+
+// GetPodcastItemsToCheckIntegrity returns the downloaded episodes whose file
+// was not checked since it was downloaded.
+func GetPodcastItemsToCheckIntegrity() (*[]PodcastItem, error) {
+	var podcastItems []PodcastItem
+	result := DB.Preload("Podcast").Preload("AlternateEnclosures").Where("download_status=? and (integrity_check_date is null or integrity_check_date<download_date)", Downloaded).Find(&podcastItems)
+	return &podcastItems, result.Error
+}
+
+func UpdatePodcastItemIntegrity(podcastItemId string, integrityError string, checkDate time.Time) error {
+	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Updates(map[string]interface{}{"integrity_error": integrityError, "integrity_check_date": checkDate})
+	return result.Error
+}
+
+func UpdatePodcastItemShowNotes(podcastItemId string, showNotes string) error {
+	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Update("show_notes", showNotes)
+	return result.Error
+}
+
+func UpdatePodcastItemGUID(podcastItemId string, guid string) error {
+	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Update("guid", guid)
+	return result.Error
+}
+
 func CreatePodcast(podcast *Podcast) error {
 	tx := DB.Create(&podcast)
 	return tx.Error
@@ -293,10 +604,76 @@ func CreatePodcastItem(podcastItem *PodcastItem) error {
 }
 
 func UpdatePodcastItem(podcastItem *PodcastItem) error {
-	tx := DB.Omit("Podcast").Save(&podcastItem)
+	tx := DB.Omit(clause.Associations).Save(&podcastItem)
+	return tx.Error
+}
+
+func CreateTranscript(transcript *Transcript) error {
+	tx := DB.Create(&transcript)
 	return tx.Error
 }
 
+// ReplaceChapters swaps all the chapters of an episode for the given ones.
+func ReplaceChapters(podcastItemId string, chapters []Chapter) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("podcast_item_id=?", podcastItemId).Delete(&Chapter{})
+		if result.Error != nil {
+			return result.Error
+		}
+		for i := range chapters {
+			chapters[i].PodcastItemID = podcastItemId
+			result = tx.Create(&chapters[i])
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+}
+
+func UpdatePodcastItemChaptersURL(podcastItemId string, chaptersURL string) error {
+	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Update("chapters_url", chaptersURL)
+	return result.Error
+}
+
+// ReplaceAlternateEnclosures swaps all the alternate enclosures of an episode
+// for the given ones.
+func ReplaceAlternateEnclosures(podcastItemId string, enclosures []AlternateEnclosure) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("podcast_item_id=?", podcastItemId).Delete(&AlternateEnclosure{})
+		if result.Error != nil {
+			return result.Error
+		}
+		for i := range enclosures {
+			enclosures[i].PodcastItemID = podcastItemId
+			result = tx.Create(&enclosures[i])
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+}
+
+func UpdatePodcastItemDownloadMediaType(podcastItemId string, mediaType string) error {
+	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Update("download_media_type", mediaType)
+	return result.Error
+}
+
+func UpdatePodcastEnclosurePreferences(podcastId string, preferredCodec string, maxBitrate int, preferredMediaKind string) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Updates(map[string]interface{}{
+		"preferred_codec":      preferredCodec,
+		"max_bitrate":          maxBitrate,
+		"preferred_media_kind": preferredMediaKind,
+	})
+	return result.Error
+}
+
+func UpdateTranscriptDownloadPath(transcriptId string, downloadPath string) error {
+	result := DB.Model(Transcript{}).Where("id=?", transcriptId).Update("download_path", downloadPath)
+	return result.Error
+}
+
 func UpdateSettings(setting *Setting) error {
 	tx := DB.Save(&setting)
 	return tx.Error
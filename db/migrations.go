@@ -26,6 +26,23 @@ func RunMigrations() {
 	}
 }
 
+// IsMigrationDone tells whether the migration of the given name already ran.
+func IsMigrationDone(name string) bool {
+	var migration Migration
+	result := DB.Where("name=?", name).First(&migration)
+	return result.Error == nil
+}
+
+// SaveMigration records that the migration of the given name ran, for
+// migrations done in code rather than with a query.
+func SaveMigration(name string) error {
+	result := DB.Save(&Migration{
+		Date: time.Now(),
+		Name: name,
+	})
+	return result.Error
+}
+
 func ExecuteAndSaveMigration(name string, query string) error {
 	var migration Migration
 	result := DB.Where("name=?", name).First(&migration)
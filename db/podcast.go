@@ -1,6 +1,7 @@
 package db
 
 import (
+	"strings"
 	"time"
 )
 
@@ -32,6 +33,85 @@ type Podcast struct {
 	AllEpisodesSize         int64 `gorm:"-"`
 
 	IsPaused bool `gorm:"default:false"`
+
+	ETag         string
+	LastModified string
+	FeedHash     string
+
+	PreviousURLs []PodcastURL
+
+	LastFetchDate       *time.Time
+	LastFetchStatus     int
+	LastFetchError      string `gorm:"type:text"`
+	ConsecutiveFailures int    `gorm:"default:0"`
+	LastSuccessDate     *time.Time
+
+	IsComplete bool `gorm:"default:false"`
+	// RefreshInterval is derived from the release cadence of the feed and
+	// RefreshIntervalOverride is set by the user, both in minutes.
+	RefreshInterval         int
+	RefreshIntervalOverride int
+	NextRefreshDate         *time.Time
+
+	// HubURL and HubTopic identify the WebSub subscription of the podcast.
+	// The lease expiry is only set once the hub has verified it, and the
+	// subscription is not asked for again before HubRetryDate.
+	HubURL         string
+	HubTopic       string
+	HubSecret      string `json:"-"`
+	HubLeaseExpiry *time.Time
+	HubRetryDate   *time.Time
+
+	// Credentials are encrypted by the service, they are never sent to the
+	// client. IsPrivate marks feeds whose URL holds a token.
+	Credentials    string `json:"-"`
+	HasCredentials bool   `gorm:"default:false"`
+	IsPrivate      bool   `gorm:"default:false"`
+
+	// BackfillArchive is set once the user asked for the archive pages of
+	// the feed to be walked. BackfillNextURL is the next page to fetch, the
+	// walk resumes from it until BackfillCompleteDate is set.
+	BackfillArchive      bool `gorm:"default:false"`
+	BackfillNextURL      string
+	BackfillPages        int
+	BackfillCompleteDate *time.Time
+
+	// Enclosure preferences of the podcast, overriding the ones of the
+	// settings when set.
+	PreferredCodec     string
+	MaxBitrate         int
+	PreferredMediaKind string
+
+	// LocalFolder is set for podcasts made of the audio files of a folder,
+	// relative to the data folder, instead of a feed. Their episodes are
+	// found by scanning it and their files are never deleted by Podgrab.
+	LocalFolder string `gorm:"index"`
+	// IsCustom marks podcasts without a feed, made of uploaded episodes.
+	IsCustom bool `gorm:"default:false"`
+}
+
+// HasFeed tells whether the episodes of the podcast come from a feed, as
+// opposed to a local folder or uploads.
+func (podcast *Podcast) HasFeed() bool {
+	return podcast.LocalFolder == "" && !podcast.IsCustom
+}
+
+// PodcastMetadataChange records a change of the channel level metadata of a
+// podcast noticed while refreshing its feed.
+type PodcastMetadataChange struct {
+	Base
+	PodcastID string `gorm:"index"`
+	Field     string
+	OldValue  string `gorm:"type:text"`
+	NewValue  string `gorm:"type:text"`
+}
+
+// PodcastURL is a previous location of the feed of a podcast, kept so that the
+// podcast can still be found by it after the feed has moved.
+type PodcastURL struct {
+	Base
+	PodcastID string
+	URL       string `gorm:"index"`
 }
 
 // PodcastItem is
@@ -41,6 +121,8 @@ type PodcastItem struct {
 	Podcast   Podcast
 	Title     string
 	Summary   string `gorm:"type:text"`
+	// ShowNotes is the sanitized HTML of the show notes.
+	ShowNotes string `gorm:"type:text"`
 
 	EpisodeType string
 
@@ -49,6 +131,10 @@ type PodcastItem struct {
 	PubDate time.Time
 
 	FileURL string
+	// EnclosureType is the media type of the enclosure and EnclosureLength
+	// its size in bytes, as given by the feed.
+	EnclosureType   string
+	EnclosureLength int64
 
 	GUID  string
 	Image string
@@ -56,6 +142,12 @@ type PodcastItem struct {
 	DownloadDate   time.Time
 	DownloadPath   string
 	DownloadStatus DownloadStatus `gorm:"default:0"`
+	// DownloadError is the error of the last failed download. Failed
+	// downloads are retried at NextDownloadRetry, which is not set anymore
+	// once DownloadAttempts reaches the limit.
+	DownloadError     string `gorm:"type:text"`
+	DownloadAttempts  int    `gorm:"default:0"`
+	NextDownloadRetry *time.Time
 
 	IsPlayed bool `gorm:"default:false"`
 
@@ -64,8 +156,81 @@ type PodcastItem struct {
 	LocalImage string
 
 	FileSize int64
+
+	// IntegrityError tells why the downloaded file did not look like a
+	// media file when it was last checked, empty when it did.
+	IntegrityError     string
+	IntegrityCheckDate *time.Time
+
+	Transcripts []Transcript
+
+	AlternateEnclosures []AlternateEnclosure
+	// DownloadMediaType is the media type of the enclosure which was
+	// downloaded, which may be an alternate one.
+	DownloadMediaType string
+
+	ChaptersURL string
+	Chapters    []Chapter
+
+	// IsRemovedUpstream marks episodes the publisher pulled from the feed.
+	// Their downloads are kept.
+	IsRemovedUpstream   bool `gorm:"default:false"`
+	RemovedUpstreamDate *time.Time
+
+	// IsArchived marks episodes backfilled from the archive pages of the
+	// feed, they are not expected in the feed itself.
+	IsArchived bool `gorm:"default:false"`
+}
+
+// AlternateEnclosure is a podcast:alternateEnclosure of an episode, another
+// encoding of it which may be downloaded instead of the main enclosure.
+type AlternateEnclosure struct {
+	Base
+	PodcastItemID string `gorm:"index"`
+	URL           string
+	Type          string
+	Length        int64
+	// Bitrate is in bits per second.
+	Bitrate   int
+	Height    int
+	Codecs    string
+	Lang      string
+	Title     string
+	IsDefault bool
+}
+
+// Transcript is a podcast:transcript attached to an episode.
+type Transcript struct {
+	Base
+	PodcastItemID string
+	URL           string
+	Type          string
+	Language      string
+	Rel           string
+	DownloadPath  string
+}
+
+// Chapter is a chapter marker of an episode, read either from the feed or from
+// the ID3 tags of the downloaded file.
+type Chapter struct {
+	Base
+	PodcastItemID string
+	StartTime     float64
+	EndTime       float64
+	Title         string
+	URL           string
+	Image         string
+	Source        ChapterSource
 }
 
+type ChapterSource string
+
+const (
+	ChapterSourceJson ChapterSource = "json"
+	ChapterSourcePsc  ChapterSource = "psc"
+	ChapterSourceID3  ChapterSource = "id3"
+)
+
 type DownloadStatus int
 
 const (
@@ -73,8 +238,24 @@ const (
 	Downloading
 	Downloaded
 	Deleted
+	Failed
 )
 
+var downloadStatusNames = map[string]DownloadStatus{
+	"notdownloaded": NotDownloaded,
+	"downloading":   Downloading,
+	"downloaded":    Downloaded,
+	"deleted":       Deleted,
+	"failed":        Failed,
+}
+
+// ParseDownloadStatus returns the download status of the given name, like
+// failed, ignoring case.
+func ParseDownloadStatus(name string) (DownloadStatus, bool) {
+	status, ok := downloadStatusNames[strings.ToLower(strings.TrimSpace(name))]
+	return status, ok
+}
+
 type Setting struct {
 	Base
 	DownloadOnAdd                 bool `gorm:"default:true"`
@@ -89,6 +270,11 @@ type Setting struct {
 	BaseUrl                       string
 	MaxDownloadConcurrency        int `gorm:"default:5"`
 	UserAgent                     string
+	// PreferredCodec, MaxBitrate (in kbps) and PreferredMediaKind (audio or
+	// video) choose among the alternate enclosures of episodes.
+	PreferredCodec     string
+	MaxBitrate         int
+	PreferredMediaKind string
 }
 type Migration struct {
 	Base
@@ -132,5 +318,6 @@ type PodcastItemConsolidateDiskStatsModel struct {
 	Downloading     int64
 	NotDownloaded   int64
 	Deleted         int64
+	Failed          int64
 	PendingDownload int64
 }
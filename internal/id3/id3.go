@@ -0,0 +1,287 @@
+// Package id3 provides a small reader for ID3v2 tags.
+//
+// Only the frames needed by Podgrab are decoded: the common text frames,
+// comments and the chapter frames (CHAP) of the ID3v2 Chapter Frame Addendum.
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ErrNoTag is returned when the file does not start with an ID3v2 tag.
+var ErrNoTag = errors.New("id3: no ID3v2 tag found")
+
+const headerSize = 10
+
+// Tag holds the decoded content of an ID3v2 tag.
+type Tag struct {
+	Version  int
+	Title    string
+	Artist   string
+	Album    string
+	Year     string
+	Genre    string
+	Comment  string
+	Length   time.Duration
+	Chapters []Chapter
+}
+
+// Chapter is a single CHAP frame.
+type Chapter struct {
+	ElementID string
+	StartTime time.Duration
+	EndTime   time.Duration
+	Title     string
+	URL       string
+}
+
+// ReadFile reads the ID3v2 tag at the start of the file at path.
+func ReadFile(path string) (*Tag, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Read reads an ID3v2 tag from the start of r.
+func Read(r io.Reader) (*Tag, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNoTag
+		}
+		return nil, err
+	}
+	if string(header[0:3]) != "ID3" {
+		return nil, ErrNoTag
+	}
+
+	version := int(header[3])
+	if version < 2 || version > 4 {
+		return nil, errors.New("id3: unsupported version 2." + strconv.Itoa(version))
+	}
+	flags := header[5]
+	size := syncsafe(header[6:10])
+
+	// The size comes from the file, only allocate what it really holds.
+	data, err := io.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if flags&0x80 != 0 && version < 4 {
+		data = removeUnsynchronisation(data)
+	}
+	if flags&0x40 != 0 && version > 2 {
+		data = skipExtendedHeader(data, version)
+	}
+
+	tag := &Tag{Version: version}
+	parseFrames(tag, data, version)
+	return tag, nil
+}
+
+func parseFrames(tag *Tag, data []byte, version int) {
+	for _, f := range readFrames(data, version) {
+		switch f.id {
+		case "TIT2", "TT2":
+			tag.Title = decodeText(f.data)
+		case "TPE1", "TP1":
+			tag.Artist = decodeText(f.data)
+		case "TALB", "TAL":
+			tag.Album = decodeText(f.data)
+		case "TYER", "TDRC", "TYE":
+			tag.Year = decodeText(f.data)
+		case "TCON", "TCO":
+			tag.Genre = decodeText(f.data)
+		case "TLEN", "TLE":
+			if ms, err := strconv.Atoi(decodeText(f.data)); err == nil {
+				tag.Length = time.Duration(ms) * time.Millisecond
+			}
+		case "COMM", "COM":
+			if tag.Comment == "" {
+				tag.Comment = decodeComment(f.data)
+			}
+		case "CHAP":
+			if chapter, ok := decodeChapter(f.data, version); ok {
+				tag.Chapters = append(tag.Chapters, chapter)
+			}
+		}
+	}
+}
+
+type frame struct {
+	id   string
+	data []byte
+}
+
+func readFrames(data []byte, version int) []frame {
+	var frames []frame
+	idSize, frameHeaderSize := 4, 10
+	if version == 2 {
+		idSize, frameHeaderSize = 3, 6
+	}
+
+	for len(data) >= frameHeaderSize {
+		if data[0] == 0 {
+			// padding
+			break
+		}
+		id := string(data[0:idSize])
+		var size int
+		switch version {
+		case 2:
+			size = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(data[4:8]))
+		default:
+			size = syncsafe(data[4:8])
+		}
+		data = data[frameHeaderSize:]
+		if size < 0 || size > len(data) {
+			break
+		}
+		frames = append(frames, frame{id: id, data: data[:size]})
+		data = data[size:]
+	}
+	return frames
+}
+
+func decodeChapter(data []byte, version int) (Chapter, bool) {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 || len(data) < end+1+16 {
+		return Chapter{}, false
+	}
+	chapter := Chapter{ElementID: string(data[:end])}
+	data = data[end+1:]
+	chapter.StartTime = time.Duration(binary.BigEndian.Uint32(data[0:4])) * time.Millisecond
+	chapter.EndTime = time.Duration(binary.BigEndian.Uint32(data[4:8])) * time.Millisecond
+	data = data[16:]
+
+	for _, f := range readFrames(data, version) {
+		switch f.id {
+		case "TIT2":
+			chapter.Title = decodeText(f.data)
+		case "WXXX":
+			chapter.URL = decodeUserURL(f.data)
+		}
+	}
+	return chapter, true
+}
+
+func decodeText(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	text := decodeString(data[0], data[1:])
+	// Multiple values are separated by null characters, keep the first one.
+	if i := strings.IndexRune(text, 0); i >= 0 {
+		text = text[:i]
+	}
+	return strings.TrimSpace(text)
+}
+
+func decodeComment(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+	encoding := data[0]
+	// skip language
+	_, rest := splitTerminated(encoding, data[4:])
+	return strings.TrimSpace(strings.TrimRight(decodeString(encoding, rest), "\x00"))
+}
+
+func decodeUserURL(data []byte) string {
+	if len(data) < 1 {
+		return ""
+	}
+	_, rest := splitTerminated(data[0], data[1:])
+	return strings.TrimSpace(strings.TrimRight(string(rest), "\x00"))
+}
+
+// splitTerminated splits data at the first string terminator of the
+// given encoding.
+func splitTerminated(encoding byte, data []byte) ([]byte, []byte) {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return data[:i], data[i+2:]
+			}
+		}
+		return data, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return data[:i], data[i+1:]
+	}
+	return data, nil
+}
+
+func decodeString(encoding byte, data []byte) string {
+	switch encoding {
+	case 1:
+		if len(data) >= 2 {
+			if data[0] == 0xFF && data[1] == 0xFE {
+				return decodeUTF16(data[2:], binary.LittleEndian)
+			}
+			if data[0] == 0xFE && data[1] == 0xFF {
+				return decodeUTF16(data[2:], binary.BigEndian)
+			}
+		}
+		return decodeUTF16(data, binary.LittleEndian)
+	case 2:
+		return decodeUTF16(data, binary.BigEndian)
+	case 3:
+		return string(data)
+	default:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+}
+
+func decodeUTF16(data []byte, order binary.ByteOrder) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, order.Uint16(data[i:i+2]))
+	}
+	return string(utf16.Decode(units))
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+func removeUnsynchronisation(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+func skipExtendedHeader(data []byte, version int) []byte {
+	if len(data) < 4 {
+		return data
+	}
+	var size int
+	if version == 4 {
+		size = syncsafe(data[0:4])
+	} else {
+		size = int(binary.BigEndian.Uint32(data[0:4])) + 4
+	}
+	if size > len(data) {
+		return nil
+	}
+	return data[size:]
+}
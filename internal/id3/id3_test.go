@@ -0,0 +1,206 @@
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// synchsafeBytes encodes n with 7 bits per byte.
+func synchsafeBytes(n int) []byte {
+	return []byte{byte(n>>21) & 0x7f, byte(n>>14) & 0x7f, byte(n>>7) & 0x7f, byte(n) & 0x7f}
+}
+
+// testFrame encodes a frame of the given version.
+func testFrame(version int, id string, data []byte) []byte {
+	frame := []byte(id)
+	switch version {
+	case 2:
+		frame = append(frame, byte(len(data)>>16), byte(len(data)>>8), byte(len(data)))
+	case 3:
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(data)))
+		frame = append(frame, 0, 0)
+	default:
+		frame = append(frame, synchsafeBytes(len(data))...)
+		frame = append(frame, 0, 0)
+	}
+	return append(frame, data...)
+}
+
+// testTag encodes a tag holding the given frames.
+func testTag(version int, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	tag := []byte{'I', 'D', '3', byte(version), 0, 0}
+	tag = append(tag, synchsafeBytes(len(body))...)
+	return append(tag, body...)
+}
+
+func textFrame(version int, id string, text string) []byte {
+	return testFrame(version, id, append([]byte{3}, text...))
+}
+
+// chapFrame encodes a CHAP frame with the given sub-frames.
+func chapFrame(version int, elementID string, start, end uint32, subFrames ...[]byte) []byte {
+	data := append([]byte(elementID), 0)
+	data = binary.BigEndian.AppendUint32(data, start)
+	data = binary.BigEndian.AppendUint32(data, end)
+	data = append(data, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	data = append(data, bytes.Join(subFrames, nil)...)
+	return testFrame(version, "CHAP", data)
+}
+
+// ctocFrame encodes a top level table of contents listing the given chapters.
+func ctocFrame(version int, chapterIDs ...string) []byte {
+	data := []byte("toc\x00")
+	data = append(data, 0x03, byte(len(chapterIDs)))
+	for _, id := range chapterIDs {
+		data = append(data, id...)
+		data = append(data, 0)
+	}
+	return testFrame(version, "CTOC", data)
+}
+
+func TestRead(t *testing.T) {
+	// 200 bytes do not fit in 7 bits, their synchsafe size differs from the
+	// plain one.
+	longTitle := string(bytes.Repeat([]byte("a"), 199))
+	intro := Chapter{ElementID: "ch0", StartTime: 0, EndTime: 1500 * time.Millisecond, Title: "Intro", URL: "https://example.com/intro"}
+
+	tests := []struct {
+		name string
+		tag  []byte
+		want *Tag
+		err  error
+	}{
+		{
+			name: "chapters with a table of contents",
+			tag: testTag(3,
+				textFrame(3, "TIT2", "Episode"),
+				ctocFrame(3, "ch0", "ch1"),
+				chapFrame(3, "ch0", 0, 1500,
+					textFrame(3, "TIT2", "Intro"),
+					testFrame(3, "WXXX", []byte("\x03\x00https://example.com/intro"))),
+				chapFrame(3, "ch1", 1500, 90000, textFrame(3, "TIT2", "Main")),
+			),
+			want: &Tag{Version: 3, Title: "Episode", Chapters: []Chapter{
+				intro,
+				{ElementID: "ch1", StartTime: 1500 * time.Millisecond, EndTime: 90 * time.Second, Title: "Main"},
+			}},
+		},
+		{
+			name: "synchsafe frame sizes",
+			tag: testTag(4,
+				textFrame(4, "TIT2", longTitle),
+				chapFrame(4, "ch0", 0, 1500, textFrame(4, "TIT2", longTitle)),
+				textFrame(4, "TPE1", "Host"),
+			),
+			want: &Tag{Version: 4, Title: longTitle, Artist: "Host", Chapters: []Chapter{
+				{ElementID: "ch0", EndTime: 1500 * time.Millisecond, Title: longTitle},
+			}},
+		},
+		{
+			name: "version 2.2 frames",
+			tag:  testTag(2, textFrame(2, "TT2", "Episode"), textFrame(2, "TLE", "61000")),
+			want: &Tag{Version: 2, Title: "Episode", Length: 61 * time.Second},
+		},
+		{
+			name: "padding",
+			tag:  testTag(3, textFrame(3, "TIT2", "Episode"), make([]byte, 32), textFrame(3, "TPE1", "Host")),
+			want: &Tag{Version: 3, Title: "Episode"},
+		},
+		{
+			name: "truncated frame header",
+			tag:  testTag(3, textFrame(3, "TIT2", "Episode"), []byte("TPE1\x00\x00")),
+			want: &Tag{Version: 3, Title: "Episode"},
+		},
+		{
+			name: "frame larger than the tag",
+			tag:  testTag(3, textFrame(3, "TIT2", "Episode"), []byte("TPE1\x00\x00\x10\x00\x00\x00\x03Host")),
+			want: &Tag{Version: 3, Title: "Episode"},
+		},
+		{
+			name: "sub-frame larger than the chapter",
+			tag: testTag(3, chapFrame(3, "ch0", 0, 1500,
+				textFrame(3, "TIT2", "Intro"),
+				[]byte("WXXX\x7f\x00\x00\x00\x00\x00\x03"))),
+			want: &Tag{Version: 3, Chapters: []Chapter{
+				{ElementID: "ch0", EndTime: 1500 * time.Millisecond, Title: "Intro"},
+			}},
+		},
+		{
+			name: "chapter without times",
+			tag:  testTag(3, testFrame(3, "CHAP", []byte("ch0\x00\x00\x00"))),
+			want: &Tag{Version: 3},
+		},
+		{
+			name: "chapter without element id terminator",
+			tag:  testTag(3, testFrame(3, "CHAP", []byte("ch0"))),
+			want: &Tag{Version: 3},
+		},
+		{
+			name: "tag larger than the file",
+			tag:  testTag(3, textFrame(3, "TIT2", "Episode"))[:15],
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "huge tag size",
+			tag:  []byte{'I', 'D', '3', 3, 0, 0, 0x7f, 0x7f, 0x7f, 0x7f},
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "no tag",
+			tag:  []byte("\xff\xfb\x90\x64\x00\x00\x00\x00\x00\x00\x00"),
+			err:  ErrNoTag,
+		},
+		{
+			name: "truncated header",
+			tag:  []byte("ID3\x03"),
+			err:  ErrNoTag,
+		},
+	}
+	for _, test := range tests {
+		got, err := Read(bytes.NewReader(test.tag))
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: Read() error = %v, want %v", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Read() error = %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Read() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadUnsupportedVersion(t *testing.T) {
+	if _, err := Read(bytes.NewReader(testTag(5))); err == nil || errors.Is(err, ErrNoTag) {
+		t.Errorf("Read() of a version 2.5 tag error = %v, want unsupported version", err)
+	}
+}
+
+func TestSyncsafe(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want int
+	}{
+		{[]byte{0, 0, 0, 0x7f}, 127},
+		{[]byte{0, 0, 1, 0}, 128},
+		{[]byte{0, 0, 1, 0x48}, 200},
+		{[]byte{0x7f, 0x7f, 0x7f, 0x7f}, 1<<28 - 1},
+		// The high bit of each byte is not part of the size.
+		{[]byte{0x80, 0x80, 0x81, 0xc8}, 200},
+	}
+	for _, test := range tests {
+		if got := syncsafe(test.b); got != test.want {
+			t.Errorf("syncsafe(% x) = %d, want %d", test.b, got, test.want)
+		}
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"path"
 	"regexp"
 	"strings"
+
+	parser "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 var (
@@ -14,10 +17,109 @@ var (
 	defaultTags = []string{"h1", "h2", "h3", "h4", "h5", "h6", "div", "span", "hr", "p", "br", "b", "i", "strong", "em", "ol", "ul", "li", "a", "img", "pre", "code", "blockquote", "article", "section"}
 
 	defaultAttributes = []string{"id", "class", "src", "href", "title", "alt", "name", "rel"}
+
+	// Elements which have no content and so no end tag.
+	voidTags = []string{"area", "br", "col", "hr", "img", "input", "source", "track", "wbr"}
 )
 
 // HTMLAllowing sanitizes html, allowing some tags.
 // Arrays of allowed tags and allowed attributes may optionally be passed as the second and third arguments.
+// The html is parsed as the content of a body element and rendered again, so
+// that the result is always well-formed: unclosed tags are closed and stray
+// end tags dropped.
+func HTMLAllowing(s string, args ...[]string) (string, error) {
+
+	allowedTags := defaultTags
+	if len(args) > 0 {
+		allowedTags = args[0]
+	}
+	allowedAttributes := defaultAttributes
+	if len(args) > 1 {
+		allowedAttributes = args[1]
+	}
+
+	// Parse the html
+	context := &parser.Node{Type: parser.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := parser.ParseFragment(strings.NewReader(s), context)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := bytes.NewBufferString("")
+	for _, node := range nodes {
+		renderAllowed(buffer, node, allowedTags, allowedAttributes)
+	}
+	return buffer.String(), nil
+}
+
+// renderAllowed writes node with only the allowed tags and attributes. The
+// children of other elements are kept, unless the element is to be ignored
+// along with its content.
+func renderAllowed(buffer *bytes.Buffer, node *parser.Node, allowedTags []string, allowedAttributes []string) {
+	switch node.Type {
+
+	case parser.TextNode:
+		buffer.WriteString(parser.EscapeString(node.Data))
+
+	case parser.ElementNode:
+		if includes(ignoreTags, node.Data) {
+			return
+		}
+		allowed := includes(allowedTags, node.Data)
+		if allowed && includes(voidTags, node.Data) {
+			token := parser.Token{Type: parser.SelfClosingTagToken, Data: node.Data, Attr: cleanAttributes(node.Attr, allowedAttributes)}
+			buffer.WriteString(token.String())
+			return
+		}
+		if allowed {
+			token := parser.Token{Type: parser.StartTagToken, Data: node.Data, Attr: cleanAttributes(node.Attr, allowedAttributes)}
+			buffer.WriteString(token.String())
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			renderAllowed(buffer, child, allowedTags, allowedAttributes)
+		}
+		if allowed {
+			token := parser.Token{Type: parser.EndTagToken, Data: node.Data}
+			buffer.WriteString(token.String())
+		}
+
+	default:
+		// We ignore comments and doctypes - html5 does not require them and this is intended for sanitizing snippets of text
+	}
+}
+
+// cleanAttributes returns an array of attributes after removing malicious ones.
+func cleanAttributes(a []parser.Attribute, allowed []string) []parser.Attribute {
+	if len(a) == 0 {
+		return a
+	}
+
+	var cleaned []parser.Attribute
+	for _, attr := range a {
+		if includes(allowed, attr.Key) {
+
+			val := strings.ToLower(attr.Val)
+
+			// Check for illegal attribute values
+			if illegalAttr.FindString(val) != "" {
+				attr.Val = ""
+			}
+
+			// Check for legal href values - / mailto:// http:// or https://
+			if attr.Key == "href" {
+				if legalHrefAttr.FindString(val) == "" {
+					attr.Val = ""
+				}
+			}
+
+			// If we still have an attribute, append it to the array
+			if attr.Val != "" {
+				cleaned = append(cleaned, attr)
+			}
+		}
+	}
+	return cleaned
+}
 
 // HTML strips html tags, replace common entities, and escapes <>&;'" in the result.
 // Note the returned text may contain entities as it is escaped by HTMLEscapeString, and most entities are not translated.
@@ -0,0 +1,141 @@
+package sanitize
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	parser "golang.org/x/net/html"
+)
+
+func TestHTMLAllowing(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{`<p onclick="steal()">Hi <b>there</b></p>`, `<p>Hi <b>there</b></p>`},
+		{`<br/><hr />`, `<br/><hr/>`},
+		{`<div><p>unclosed`, `<div><p>unclosed</p></div>`},
+		{`text</div></span>more`, `textmore`},
+		{`</div><div>x`, `<div>x</div>`},
+		{`<p>a<p>b`, `<p>a</p><p>b</p>`},
+		{`<b><i>x</b>y</i>`, `<b><i>x</i></b><i>y</i>`},
+		{`<ul><li>one<li>two</ul>`, `<ul><li>one</li><li>two</li></ul>`},
+		{`<table><tr><td>cell`, `cell`},
+		{`<unknown>kept text</unknown>`, `kept text`},
+		{`<!-- comment --><!DOCTYPE html>text`, `text`},
+		{`<noscript><img src=x onerror=alert(1)></noscript>`, `&lt;img src=x onerror=alert(1)&gt;`},
+		{`<textarea><b>x</b></textarea>`, `&lt;b&gt;x&lt;/b&gt;`},
+		{`1 &lt; 2 &amp; "q"`, `1 &lt; 2 &amp; &#34;q&#34;`},
+		{`<p title="a&quot;b">x</p>`, `<p title="a&#34;b">x</p>`},
+
+		// Ignored elements are dropped along with their content.
+		{`<script>alert(1)</script>text`, `text`},
+		{`<style>p{}</style><iframe src="x">inner <b>b</b></iframe>after`, `after`},
+		{`<object data="x"><embed src="x">fallback</object>after`, `after`},
+
+		// Links are only kept to the web, mail and the same site.
+		{`<a href="https://example.com/?a=1&b=2" title="t" target="_blank">x</a>`, `<a href="https://example.com/?a=1&amp;b=2" title="t">x</a>`},
+		{`<a href="http://example.com">x</a>`, `<a href="http://example.com">x</a>`},
+		{`<a href="mailto:host@example.com">x</a>`, `<a href="mailto:host@example.com">x</a>`},
+		{`<a href="/path">x</a>`, `<a href="/path">x</a>`},
+		{`<a href="#notes">x</a>`, `<a href="#notes">x</a>`},
+		{`<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{`<a href=" JaVa&#x09;Script:alert(1)">x</a>`, `<a>x</a>`},
+		{`<a href="data:text/html,hi">x</a>`, `<a>x</a>`},
+		{`<a href="ftp://example.com/file">x</a>`, `<a>x</a>`},
+		{`<a href="vbscript:msgbox">x</a>`, `<a>x</a>`},
+
+		// Other attributes lose data: and javascript: values.
+		{`<img src="https://example.com/x.png" onerror="steal()">`, `<img src="https://example.com/x.png"/>`},
+		{`<img src="data:image/png;base64,xx" alt="a">`, `<img alt="a"/>`},
+		{`<span title="javascript:alert(1)">x</span>`, `<span>x</span>`},
+	}
+	for _, test := range tests {
+		got, err := HTMLAllowing(test.html)
+		if err != nil {
+			t.Errorf("HTMLAllowing(%q) error = %v", test.html, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("HTMLAllowing(%q) = %q, want %q", test.html, got, test.want)
+		}
+	}
+}
+
+func TestHTMLAllowingLists(t *testing.T) {
+	tags := []string{"p", "a"}
+	attributes := []string{"href"}
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<p class="c" id="i">x</p>`, `<p>x</p>`},
+		{`<a href="https://example.com" rel="nofollow" title="t">x</a>`, `<a href="https://example.com">x</a>`},
+		{`<div><img src="https://example.com/x.png">text</div>`, `text`},
+		{`<script>alert(1)</script>`, ``},
+	}
+	for _, test := range tests {
+		got, err := HTMLAllowing(test.html, tags, attributes)
+		if err != nil {
+			t.Errorf("HTMLAllowing(%q) error = %v", test.html, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("HTMLAllowing(%q) = %q, want %q", test.html, got, test.want)
+		}
+	}
+}
+
+// TestHTMLAllowingBalancesTags checks that every tag opened in the output of
+// malformed html is closed, in order, so that it cannot break the page it is
+// inserted in.
+func TestHTMLAllowingBalancesTags(t *testing.T) {
+	tests := []string{
+		`<div><div><p>unclosed`,
+		`</div></div></section>stray end tags`,
+		`<table><tr><td><div>cell`,
+		`<ul><li><b>one<li><i>two</ul></b>`,
+		`<blockquote><pre><code>code</blockquote>`,
+		`<a href="https://example.com"><div><a href="https://example.org">nested</div>`,
+		`<h1><h2>headings</h1>`,
+		`<span><p>block in inline</span></p>`,
+	}
+	for _, html := range tests {
+		got, err := HTMLAllowing(html)
+		if err != nil {
+			t.Errorf("HTMLAllowing(%q) error = %v", html, err)
+			continue
+		}
+		if err := checkBalanced(got); err != "" {
+			t.Errorf("HTMLAllowing(%q) = %q: %s", html, got, err)
+		}
+	}
+}
+
+func checkBalanced(html string) string {
+	var open []string
+	tokenizer := parser.NewTokenizer(strings.NewReader(html))
+	for {
+		switch tokenizer.Next() {
+		case parser.ErrorToken:
+			if tokenizer.Err() != io.EOF {
+				return tokenizer.Err().Error()
+			}
+			if len(open) > 0 {
+				return "unclosed " + strings.Join(open, ", ")
+			}
+			return ""
+		case parser.StartTagToken:
+			open = append(open, tokenizer.Token().Data)
+		case parser.EndTagToken:
+			name := tokenizer.Token().Data
+			if len(open) == 0 || open[len(open)-1] != name {
+				return "unexpected end tag " + name
+			}
+			open = open[:len(open)-1]
+		}
+	}
+}
@@ -47,6 +47,7 @@ func run(ctx context.Context, args []string, w io.Writer) error {
 	}
 
 	go controllers.HandleWebsocketMessages()
+	service.SetDownloadListener(controllers.BroadcastDownloadProgress)
 
 	go assetEnv()
 	go intiCron()
@@ -121,6 +122,10 @@ func setupRouter() *gin.Engine {
 			}
 			return count
 		},
+		// Show notes are sanitized before they are stored.
+		"showNotes": func(showNotes string) template.HTML {
+			return template.HTML(showNotes)
+		},
 		"formatFileSize": func(inputSize int64) string {
 			size := float64(inputSize)
 			const divisor float64 = 1024
@@ -181,22 +186,31 @@ func setupRouter() *gin.Engine {
 	router.Static("/assets", dataPath)
 	router.Static(backupPath, backupPath)
 	router.POST("/podcasts", controllers.AddPodcast)
+	router.POST("/podcasts/local", controllers.AddLocalPodcast)
 	router.GET("/podcasts", controllers.GetAllPodcasts)
 	router.GET("/podcasts/:id", controllers.GetPodcastById)
 	router.GET("/podcasts/:id/image", controllers.GetPodcastImageById)
 	router.DELETE("/podcasts/:id", controllers.DeletePodcastById)
+	router.PATCH("/podcasts/:id", controllers.PatchPodcastById)
 	router.GET("/podcasts/:id/items", controllers.GetPodcastItemsByPodcastId)
 	router.GET("/podcasts/:id/download", controllers.DownloadAllEpisodesByPodcastId)
 	router.DELETE("/podcasts/:id/items", controllers.DeletePodcastEpisodesById)
 	router.DELETE("/podcasts/:id/podcast", controllers.DeleteOnlyPodcastById)
 	router.GET("/podcasts/:id/pause", controllers.PausePodcastById)
 	router.GET("/podcasts/:id/unpause", controllers.UnpausePodcastById)
+	router.GET("/podcasts/:id/backfill", controllers.BackfillPodcastArchiveById)
 	router.GET("/podcasts/:id/rss", controllers.GetRssForPodcastById)
+	router.GET("/podcasts/:id/metadataChanges", controllers.GetPodcastMetadataChangesById)
+	router.GET("/podcasts/:id/duplicates", controllers.GetSuspectedDuplicatesByPodcastId)
 
 	router.GET("/podcastitems", controllers.GetAllPodcastItems)
+	router.POST("/podcastitems/upload", controllers.UploadPodcastItem)
+	router.GET("/duplicates", controllers.GetSuspectedDuplicates)
 	router.GET("/podcastitems/:id", controllers.GetPodcastItemById)
 	router.GET("/podcastitems/:id/image", controllers.GetPodcastItemImageById)
 	router.GET("/podcastitems/:id/file", controllers.GetPodcastItemFileById)
+	router.GET("/podcastitems/:id/transcript", controllers.GetPodcastItemTranscriptById)
+	router.GET("/podcastitems/:id/chapters", controllers.GetPodcastItemChaptersById)
 	router.GET("/podcastitems/:id/markUnplayed", controllers.MarkPodcastItemAsUnplayed)
 	router.GET("/podcastitems/:id/markPlayed", controllers.MarkPodcastItemAsPlayed)
 	router.GET("/podcastitems/:id/bookmark", controllers.BookmarkPodcastItem)
@@ -205,6 +219,9 @@ func setupRouter() *gin.Engine {
 	router.GET("/podcastitems/:id/download", controllers.DownloadPodcastItem)
 	router.GET("/podcastitems/:id/delete", controllers.DeletePodcastItem)
 
+	router.GET("/downloads", controllers.GetDownloads)
+	router.GET("/downloads/progress", controllers.GetDownloadProgress)
+
 	router.GET("/tags", controllers.GetAllTags)
 	router.GET("/tags/:id", controllers.GetTagById)
 	router.GET("/tags/:id/rss", controllers.GetRssForTagById)
@@ -227,6 +244,11 @@ func setupRouter() *gin.Engine {
 	router.GET("/player", controllers.PlayerPage)
 	router.GET("/rss", controllers.GetRss)
 
+	// Hubs cannot authenticate, the callback is checked against the
+	// subscription instead.
+	r.GET("/websub/:id", controllers.VerifyWebSubSubscription)
+	r.POST("/websub/:id", controllers.ReceiveWebSubNotification)
+
 	r.GET("/ws", func(c *gin.Context) {
 		controllers.WSHandler(c.Writer, c.Request)
 	})
@@ -252,13 +274,21 @@ func intiCron() {
 		log.Print(err)
 	}
 	service.UnlockMissedJobs()
+	service.ResetInterruptedDownloads()
+	go service.ReparseDurationsAndDates()
 	// gocron.Every(uint64(checkFrequency)).Minutes().Do(service.DownloadMissingEpisodes)
 	gocron.Every(uint64(checkFrequency)).Minutes().Do(service.RefreshEpisodes)
 	gocron.Every(uint64(checkFrequency)).Minutes().Do(service.CheckMissingFiles)
+	gocron.Every(1).Hour().Do(service.CheckDownloadIntegrity)
+	gocron.Every(uint64(checkFrequency)).Minutes().Do(service.ScanLocalPodcasts)
 	gocron.Every(uint64(checkFrequency) * 2).Minutes().Do(service.UnlockMissedJobs)
 	gocron.Every(uint64(checkFrequency) * 3).Minutes().Do(service.UpdateAllFileSizes)
 	gocron.Every(uint64(checkFrequency)).Minutes().Do(service.DownloadMissingImages)
+	gocron.Every(uint64(checkFrequency)).Minutes().Do(service.DownloadMissingTranscripts)
 	gocron.Every(2).Days().Do(service.CreateBackup)
+	gocron.Every(1).Hour().Do(service.RenewWebSubSubscriptions)
+	gocron.Every(1).Hour().Do(service.ResumeArchiveBackfills)
+	gocron.Every(1).Day().Do(service.ReparseDurationsAndDates)
 	<-gocron.Start()
 }
 
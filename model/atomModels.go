@@ -0,0 +1,62 @@
+package model
+
+import "encoding/xml"
+
+// AtomFeed is the struct that represents an Atom (RFC 4287) feed.
+// Only the elements needed to ingest a podcast are mapped.
+type AtomFeed struct {
+	XMLName  xml.Name    `xml:"feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Logo     string      `xml:"logo"`
+	Icon     string      `xml:"icon"`
+	Author   AtomPerson  `xml:"author"`
+	Link     []AtomLink  `xml:"link"`
+	Image    ItunesImage `xml:"image"`
+	Entry    []AtomEntry `xml:"entry"`
+}
+
+type AtomEntry struct {
+	Title      string           `xml:"title"`
+	ID         string           `xml:"id"`
+	Updated    string           `xml:"updated"`
+	Published  string           `xml:"published"`
+	Summary    AtomText         `xml:"summary"`
+	Content    AtomText         `xml:"content"`
+	Author     AtomPerson       `xml:"author"`
+	Link       []AtomLink       `xml:"link"`
+	Duration   string           `xml:"duration"`
+	Image      ItunesImage      `xml:"image"`
+	Transcript []FeedTranscript `xml:"transcript"`
+	Chapters   []FeedChapters   `xml:"chapters"`
+	Group      struct {
+		Thumbnail struct {
+			URL string `xml:"url,attr"`
+		} `xml:"thumbnail"`
+		Description string `xml:"description"`
+	} `xml:"group"`
+}
+
+type AtomText struct {
+	Text string `xml:",chardata"`
+	Type string `xml:"type,attr"`
+}
+
+type AtomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email"`
+	URI   string `xml:"uri"`
+}
+
+type AtomLink struct {
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Href   string `xml:"href,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type ItunesImage struct {
+	Href string `xml:"href,attr"`
+}
@@ -0,0 +1,16 @@
+package model
+
+// ChaptersJson is the JSON chapters format of the Podcasting 2.0 namespace.
+type ChaptersJson struct {
+	Version  string        `json:"version"`
+	Chapters []ChapterJson `json:"chapters"`
+}
+
+type ChapterJson struct {
+	StartTime float64 `json:"startTime"`
+	EndTime   float64 `json:"endTime,omitempty"`
+	Title     string  `json:"title,omitempty"`
+	Img       string  `json:"img,omitempty"`
+	URL       string  `json:"url,omitempty"`
+	Toc       *bool   `json:"toc,omitempty"`
+}
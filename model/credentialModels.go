@@ -0,0 +1,16 @@
+package model
+
+// FeedCredentials are what a private feed needs to be fetched. They are sent
+// with every request made for the podcast: the feed, its enclosures and its
+// images.
+type FeedCredentials struct {
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	Headers  map[string]string `json:"headers"`
+	Cookies  map[string]string `json:"cookies"`
+}
+
+// IsEmpty reports whether there is nothing to send.
+func (c *FeedCredentials) IsEmpty() bool {
+	return c == nil || (c.Username == "" && c.Password == "" && len(c.Headers) == 0 && len(c.Cookies) == 0)
+}
@@ -0,0 +1,94 @@
+package model
+
+// Feed is the format agnostic representation of a podcast feed.
+// RSS and Atom documents are both normalized into it before ingestion.
+type Feed struct {
+	Title   string
+	Summary string
+	Author  string
+	Image   string
+	// NewFeedURL is the location the publisher moved the feed to, if any.
+	NewFeedURL string
+	// IsComplete is set when the publisher marked the show as finished.
+	IsComplete bool
+	// HubURL is the WebSub hub advertised by the feed and SelfURL the
+	// canonical URL of the feed, used as the WebSub topic.
+	HubURL  string
+	SelfURL string
+	// NextPageURL and PrevArchiveURL link to the older items of paged and
+	// archived feeds (RFC 5005).
+	NextPageURL    string
+	PrevArchiveURL string
+	Items          []FeedItem
+}
+
+// FeedItem is a single episode of a normalized Feed.
+type FeedItem struct {
+	Title       string
+	Summary     string
+	Description string
+	// Content is the HTML show notes of the item, as found in the feed.
+	Content     string
+	EpisodeType string
+	Duration    string
+	PubDate     string
+	GUID        string
+	Image       string
+	Enclosure   FeedEnclosure
+	// AlternateEnclosures are the other encodings offered for the item.
+	AlternateEnclosures []FeedAlternateEnclosure
+	Transcripts         []FeedTranscript
+	ChaptersURL         string
+	Chapters            []FeedChapter
+}
+
+type FeedEnclosure struct {
+	URL    string
+	Length string
+	Type   string
+}
+
+// FeedAlternateEnclosure maps the podcast:alternateEnclosure tag of the
+// Podcasting 2.0 namespace.
+type FeedAlternateEnclosure struct {
+	Type    string                `xml:"type,attr"`
+	Length  string                `xml:"length,attr"`
+	Bitrate string                `xml:"bitrate,attr"`
+	Height  string                `xml:"height,attr"`
+	Lang    string                `xml:"lang,attr"`
+	Title   string                `xml:"title,attr"`
+	Codecs  string                `xml:"codecs,attr"`
+	Default string                `xml:"default,attr"`
+	Sources []FeedAlternateSource `xml:"source"`
+}
+
+// FeedAlternateSource maps a podcast:source of an alternate enclosure.
+type FeedAlternateSource struct {
+	URI         string `xml:"uri,attr"`
+	ContentType string `xml:"contentType,attr"`
+}
+
+// FeedTranscript maps the podcast:transcript tag of the Podcasting 2.0 namespace.
+type FeedTranscript struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Language string `xml:"language,attr"`
+	Rel      string `xml:"rel,attr"`
+}
+
+// FeedChapters maps both the podcast:chapters tag, which links to a JSON
+// chapters file, and the Podlove psc:chapters tag, which lists the chapters
+// inline. They share the same local name so they are decoded together.
+type FeedChapters struct {
+	URL     string        `xml:"url,attr"`
+	Type    string        `xml:"type,attr"`
+	Chapter []FeedChapter `xml:"chapter"`
+}
+
+// FeedChapter maps a Podlove simple chapter.
+type FeedChapter struct {
+	Start string `xml:"start,attr"`
+	Title string `xml:"title,attr"`
+	Href  string `xml:"href,attr"`
+	Image string `xml:"image,attr"`
+}
@@ -33,10 +33,12 @@ type PodcastData struct {
 			Name  string `xml:"name"`
 			Email string `xml:"email"`
 		} `xml:"owner"`
-		Author    string `xml:"author"`
-		Copyright string `xml:"copyright"`
-		Explicit  string `xml:"explicit"`
-		Category  struct {
+		Author     string `xml:"author"`
+		NewFeedUrl string `xml:"new-feed-url"`
+		Complete   string `xml:"complete"`
+		Copyright  string `xml:"copyright"`
+		Explicit   string `xml:"explicit"`
+		Category   struct {
 			Text     string `xml:",chardata"`
 			AttrText string `xml:"text,attr"`
 			Category struct {
@@ -85,9 +87,12 @@ type PodcastData struct {
 				Length string `xml:"length,attr"`
 				Type   string `xml:"type,attr"`
 			} `xml:"enclosure"`
-			Link       string `xml:"link"`
-			StitcherId string `xml:"stitcherId"`
-			Episode    string `xml:"episode"`
+			Link               string                   `xml:"link"`
+			StitcherId         string                   `xml:"stitcherId"`
+			Episode            string                   `xml:"episode"`
+			Transcript         []FeedTranscript         `xml:"transcript"`
+			Chapters           []FeedChapters           `xml:"chapters"`
+			AlternateEnclosure []FeedAlternateEnclosure `xml:"alternateEnclosure"`
 		} `xml:"item"`
 	} `xml:"channel"`
 }
@@ -10,11 +10,12 @@ type RssPodcastData struct {
 	Itunes     string     `xml:"itunes,attr"`
 	Atom       string     `xml:"atom,attr"`
 	Media      string     `xml:"media,attr"`
-	Psc        string     `xml:"psc,attr"`
+	Psc        string     `xml:"xmlns:psc,attr"`
 	Omny       string     `xml:"omny,attr"`
-	Content    string     `xml:"content,attr"`
+	Content    string     `xml:"xmlns:content,attr"`
 	Googleplay string     `xml:"googleplay,attr"`
 	Acast      string     `xml:"acast,attr"`
+	Podcast    string     `xml:"xmlns:podcast,attr,omitempty"`
 	Version    string     `xml:"version,attr"`
 	Channel    RssChannel `xml:"channel"`
 }
@@ -31,21 +32,24 @@ type RssChannel struct {
 	Author      string       `xml:"author"`
 }
 type RssItem struct {
-	Text        string           `xml:",chardata"`
-	Title       string           `xml:"title"`
-	Description string           `xml:"description"`
-	Encoded     string           `xml:"encoded"`
-	Summary     string           `xml:"summary"`
-	EpisodeType string           `xml:"episodeType"`
-	Author      string           `xml:"author"`
-	Image       RssItemImage     `xml:"image"`
-	Guid        RssItemGuid      `xml:"guid"`
-	ClipId      string           `xml:"clipId"`
-	PubDate     string           `xml:"pubDate"`
-	Duration    string           `xml:"duration"`
-	Enclosure   RssItemEnclosure `xml:"enclosure"`
-	Link        string           `xml:"link"`
-	Episode     string           `xml:"episode"`
+	Text        string              `xml:",chardata"`
+	Title       string              `xml:"title"`
+	Description string              `xml:"description"`
+	Encoded     string              `xml:"content:encoded,omitempty"`
+	Summary     string              `xml:"summary"`
+	EpisodeType string              `xml:"episodeType"`
+	Author      string              `xml:"author"`
+	Image       RssItemImage        `xml:"image"`
+	Guid        RssItemGuid         `xml:"guid"`
+	ClipId      string              `xml:"clipId"`
+	PubDate     string              `xml:"pubDate"`
+	Duration    string              `xml:"duration"`
+	Enclosure   RssItemEnclosure    `xml:"enclosure"`
+	Link        string              `xml:"link"`
+	Episode     string              `xml:"episode"`
+	Transcript  []RssItemTranscript `xml:"podcast:transcript"`
+	Chapters    *RssItemChapters    `xml:"podcast:chapters"`
+	PscChapters *RssItemPscChapters `xml:"psc:chapters"`
 }
 
 type RssItemEnclosure struct {
@@ -64,3 +68,27 @@ type RssItemGuid struct {
 	Text        string `xml:",chardata"`
 	IsPermaLink string `xml:"isPermaLink,attr"`
 }
+
+type RssItemTranscript struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Language string `xml:"language,attr,omitempty"`
+	Rel      string `xml:"rel,attr,omitempty"`
+}
+
+type RssItemChapters struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type RssItemPscChapters struct {
+	Version string              `xml:"version,attr"`
+	Chapter []RssItemPscChapter `xml:"psc:chapter"`
+}
+
+type RssItemPscChapter struct {
+	Start string `xml:"start,attr"`
+	Title string `xml:"title,attr"`
+	Href  string `xml:"href,attr,omitempty"`
+	Image string `xml:"image,attr,omitempty"`
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/model"
+	pkgErrors "github.com/pkg/errors"
+)
+
+const (
+	defaultBackfillMaxPages = 20
+	// Archive pages are fetched one after the other, this far apart, to
+	// stay polite with the publisher.
+	backfillPageDelay = 2 * time.Second
+	// Number of archives walked at once.
+	backfillWorkers = 2
+)
+
+// Podcasts whose archive is being walked, so that the job and the action of
+// the user never walk the same one twice at once.
+var backfillsRunning sync.Map
+
+var (
+	backfillPool     *feedPool
+	backfillPoolOnce sync.Once
+)
+
+// getBackfillPool returns the pool archives are walked in. A walk lasts for
+// many pages, it only takes a slot of the feed pool while fetching one of
+// them so that refreshes get their turn in between.
+func getBackfillPool() *feedPool {
+	backfillPoolOnce.Do(func() {
+		backfillPool = newFeedPool(backfillWorkers, 1)
+	})
+	return backfillPool
+}
+
+// BackfillPodcastArchive opts the podcast in to archive backfilling and
+// starts walking its archive pages in the background.
+func BackfillPodcastArchive(id string) error {
+	var podcast db.Podcast
+	err := db.GetPodcastById(id, &podcast)
+	if err != nil {
+		return err
+	}
+	if !podcast.HasFeed() {
+		return errors.New("podcast has no feed to backfill")
+	}
+	if !podcast.BackfillArchive || podcast.BackfillCompleteDate != nil {
+		podcast.BackfillArchive = true
+		podcast.BackfillCompleteDate = nil
+		podcast.BackfillNextURL = ""
+		podcast.BackfillPages = 0
+		err = db.UpdatePodcastBackfill(&podcast)
+		if err != nil {
+			return err
+		}
+	}
+	go runBackfills([]db.Podcast{podcast})
+	return nil
+}
+
+// ResumeArchiveBackfills carries on with the backfills which were cut short by
+// the page limit, an error or a restart.
+func ResumeArchiveBackfills() error {
+	const JOB_NAME = "ResumeArchiveBackfills"
+	lock := db.GetLock(JOB_NAME)
+	if lock.IsLocked() {
+		fmt.Println(JOB_NAME + " is locked")
+		return nil
+	}
+	db.Lock(JOB_NAME, 120)
+	defer db.Unlock(JOB_NAME)
+
+	podcasts, err := db.GetPodcastsWithPendingBackfill()
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to get podcasts with pending backfill")
+	}
+	runBackfills(*podcasts)
+	return nil
+}
+
+func runBackfills(podcasts []db.Podcast) {
+	var tasks []feedTask
+	for i := range podcasts {
+		podcast := podcasts[i]
+		tasks = append(tasks, feedTask{URL: podcast.URL, Fetch: func(ctx context.Context) {
+			if _, running := backfillsRunning.LoadOrStore(podcast.ID, true); running {
+				return
+			}
+			defer backfillsRunning.Delete(podcast.ID)
+
+			err := backfillArchive(ctx, &podcast)
+			if err != nil {
+				Logger.Errorw("Error backfilling archive of "+podcast.Title, err)
+			}
+		}})
+	}
+	getBackfillPool().Run(context.Background(), tasks)
+}
+
+// backfillArchive walks the next and prev-archive pages of the feed, at most
+// BACKFILL_MAX_PAGES of them per run, inserting the episodes it does not know
+// yet as not to be downloaded. Progress is saved after every page.
+func backfillArchive(ctx context.Context, podcast *db.Podcast) error {
+	credentials := getPodcastCredentials(podcast)
+	pageURL := podcast.BackfillNextURL
+	if pageURL == "" {
+		data, err := fetchFeedPage(ctx, podcast.URL, credentials)
+		if err != nil {
+			return err
+		}
+		pageURL = olderPageURL(podcast.URL, &data)
+	}
+
+	maxPages := getPositiveIntEnv("BACKFILL_MAX_PAGES", defaultBackfillMaxPages)
+	visited := map[string]bool{podcast.URL: true}
+	for pages := 0; pageURL != "" && pages < maxPages; pages++ {
+		if visited[pageURL] {
+			fmt.Println("Archive pages loop back on themselves: " + RedactURL(pageURL))
+			pageURL = ""
+			break
+		}
+		visited[pageURL] = true
+		if pages > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backfillPageDelay):
+			}
+		}
+
+		data, err := fetchFeedPage(ctx, pageURL, credentials)
+		if err != nil {
+			return err
+		}
+		added, err := addArchivedPodcastItems(podcast, &data)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Backfilled %d episodes of %s from archive page %d\n", added, podcast.Title, podcast.BackfillPages+1)
+
+		pageURL = olderPageURL(pageURL, &data)
+		podcast.BackfillPages++
+		podcast.BackfillNextURL = pageURL
+		err = db.UpdatePodcastBackfill(podcast)
+		if err != nil {
+			return err
+		}
+	}
+
+	if pageURL == "" {
+		now := time.Now()
+		podcast.BackfillNextURL = ""
+		podcast.BackfillCompleteDate = &now
+		fmt.Println("Archive backfill complete: " + podcast.Title)
+	}
+	return db.UpdatePodcastBackfill(podcast)
+}
+
+// fetchFeedPage fetches and parses a page of the archive in a slot of the
+// feed pool.
+func fetchFeedPage(ctx context.Context, pageURL string, credentials *model.FeedCredentials) (model.Feed, error) {
+	var resp *feedResponse
+	var err error
+	ran := getFeedPool().Do(ctx, feedTask{URL: pageURL, Fetch: func(ctx context.Context) {
+		resp, err = makeConditionalQuery(ctx, pageURL, "", "", credentials)
+	}})
+	if !ran {
+		return model.Feed{}, ctx.Err()
+	}
+	if err != nil {
+		return model.Feed{}, err
+	}
+	data, err := ParseFeed(resp.Body)
+	if err != nil {
+		return model.Feed{}, pkgErrors.Wrap(err, "failed to parse archive page")
+	}
+	return data, nil
+}
+
+// olderPageURL returns the page holding the items before the given page,
+// resolved against its URL.
+func olderPageURL(pageURL string, data *model.Feed) string {
+	link := data.NextPageURL
+	if link == "" {
+		link = data.PrevArchiveURL
+	}
+	if link == "" {
+		return ""
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	resolved, err := base.Parse(link)
+	if err != nil || (resolved.Scheme != "http" && resolved.Scheme != "https") {
+		return ""
+	}
+	return resolved.String()
+}
+
+func addArchivedPodcastItems(podcast *db.Podcast, data *model.Feed) (int, error) {
+	var guids []string
+	for _, item := range data.Items {
+		if item.GUID != "" {
+			guids = append(guids, item.GUID)
+		}
+	}
+	if len(guids) == 0 {
+		return 0, nil
+	}
+	existingItems, err := db.GetPodcastItemsByPodcastIdAndGUIDs(podcast.ID, guids)
+	if err != nil {
+		return 0, pkgErrors.Wrap(err, "failed to get podcast items by podcast id and guids")
+	}
+	existing := make(map[string]bool)
+	for _, item := range *existingItems {
+		existing[item.GUID] = true
+	}
+
+	added := 0
+	for i := range data.Items {
+		obj := data.Items[i]
+		if obj.GUID == "" || existing[obj.GUID] {
+			continue
+		}
+		existing[obj.GUID] = true
+
+		duration, _ := ParseDuration(obj.Duration)
+		pubDate, _ := ParsePubDate(obj.PubDate)
+		podcastItem := newPodcastItem(podcast, &obj, duration, pubDate, db.Deleted)
+		podcastItem.IsArchived = true
+		err := db.CreatePodcastItem(&podcastItem)
+		if err != nil {
+			return added, pkgErrors.Wrap(err, "failed to create podcast item")
+		}
+		added++
+	}
+	return added, nil
+}
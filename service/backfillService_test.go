@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+)
+
+func TestBackfillLeavesFeedPoolSlotsBetweenPages(t *testing.T) {
+	setupTestDB(t)
+
+	// A feed pool of a single slot, which the refresh below only gets when the
+	// backfill leaves it.
+	saved := getFeedPool()
+	sharedFeedPool = newFeedPool(1, 1)
+	t.Cleanup(func() { sharedFeedPool = saved })
+
+	pageFetched := make(chan string, 3)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		older := ""
+		switch page {
+		case "":
+			older = `<atom:link rel="prev-archive" href="` + server.URL + `/feed.xml?page=1"/>`
+		case "1":
+			older = `<atom:link rel="prev-archive" href="` + server.URL + `/feed.xml?page=2"/>`
+		}
+		w.Write([]byte(`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel><title>Show</title>` + older +
+			`<item><title>Episode</title><guid>page` + page + `</guid></item></channel></rss>`))
+		pageFetched <- page
+	}))
+	defer server.Close()
+
+	podcast := db.Podcast{Title: "Show", URL: server.URL + "/feed.xml", BackfillArchive: true}
+	if err := db.CreatePodcast(&podcast); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		runBackfills([]db.Podcast{podcast})
+		close(done)
+	}()
+
+	for _, want := range []string{"", "1"} {
+		select {
+		case page := <-pageFetched:
+			if page != want {
+				t.Fatalf("fetched page %q, want %q", page, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("page %q not fetched", want)
+		}
+	}
+
+	// The backfill waits before fetching page 2, a refresh runs meanwhile.
+	ctx, cancel := context.WithTimeout(context.Background(), backfillPageDelay/2)
+	defer cancel()
+	refreshed := getFeedPool().Do(ctx, feedTask{URL: "https://example.com/feed.xml", Fetch: func(ctx context.Context) {}})
+	if !refreshed {
+		t.Error("refresh waited for the backfill to leave the feed pool")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("backfill did not finish")
+	}
+	var backfilled db.Podcast
+	if err := db.GetPodcastById(podcast.ID, &backfilled); err != nil {
+		t.Fatal(err)
+	}
+	if backfilled.BackfillCompleteDate == nil || backfilled.BackfillPages != 2 {
+		t.Errorf("backfill complete on %v after %d pages, want complete after 2", backfilled.BackfillCompleteDate, backfilled.BackfillPages)
+	}
+}
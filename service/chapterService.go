@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/internal/id3"
+	"github.com/akhilrex/podgrab/model"
+	pkgErrors "github.com/pkg/errors"
+)
+
+const chaptersJsonVersion = "1.2.0"
+
+func toChapters(feedChapters []model.FeedChapter) []db.Chapter {
+	var chapters []db.Chapter
+	for _, feedChapter := range feedChapters {
+		start, ok := parseChapterTime(feedChapter.Start)
+		if !ok {
+			continue
+		}
+		chapters = append(chapters, db.Chapter{
+			StartTime: start,
+			Title:     feedChapter.Title,
+			URL:       feedChapter.Href,
+			Image:     feedChapter.Image,
+			Source:    db.ChapterSourcePsc,
+		})
+	}
+	return chapters
+}
+
+// addMissingChapters records the chapters of the feed for an existing episode
+// which does not have any yet. It returns true when a JSON chapters file has
+// to be fetched for the episode.
+func addMissingChapters(podcastItem *db.PodcastItem, feedItem *model.FeedItem) (bool, error) {
+	if feedItem.ChaptersURL != "" && feedItem.ChaptersURL != podcastItem.ChaptersURL {
+		err := db.UpdatePodcastItemChaptersURL(podcastItem.ID, feedItem.ChaptersURL)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if len(podcastItem.Chapters) > 0 || len(feedItem.Chapters) == 0 {
+		return false, nil
+	}
+	return false, db.ReplaceChapters(podcastItem.ID, toChapters(feedItem.Chapters))
+}
+
+// fetchChaptersForItems fetches the JSON chapters of the given episodes one
+// after the other.
+func fetchChaptersForItems(podcastItemIds []string) {
+	for _, id := range podcastItemIds {
+		err := FetchJsonChapters(id)
+		if err != nil {
+			Logger.Errorw("Error fetching chapters for "+id, err)
+		}
+	}
+}
+
+// FetchJsonChapters downloads the podcast:chapters file of an episode and
+// replaces its chapters with the ones it lists.
+func FetchJsonChapters(podcastItemId string) error {
+	var podcastItem db.PodcastItem
+	err := db.GetPodcastItemById(podcastItemId, &podcastItem)
+	if err != nil {
+		return err
+	}
+	if podcastItem.ChaptersURL == "" {
+		return nil
+	}
+
+	body, err := makeQuery(podcastItem.ChaptersURL, getPodcastCredentials(&podcastItem.Podcast))
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to get chapters")
+	}
+
+	var data model.ChaptersJson
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to parse chapters")
+	}
+
+	var chapters []db.Chapter
+	for _, chapter := range data.Chapters {
+		if chapter.Toc != nil && !*chapter.Toc {
+			continue
+		}
+		chapters = append(chapters, db.Chapter{
+			StartTime: chapter.StartTime,
+			EndTime:   chapter.EndTime,
+			Title:     chapter.Title,
+			URL:       chapter.URL,
+			Image:     chapter.Img,
+			Source:    db.ChapterSourceJson,
+		})
+	}
+	if len(chapters) == 0 {
+		return nil
+	}
+	return db.ReplaceChapters(podcastItem.ID, chapters)
+}
+
+// readChaptersFromFile reads the ID3 CHAP frames of a downloaded episode when
+// the feed did not provide any chapters.
+func readChaptersFromFile(podcastItemId string) error {
+	var podcastItem db.PodcastItem
+	err := db.GetPodcastItemById(podcastItemId, &podcastItem)
+	if err != nil {
+		return err
+	}
+	if len(podcastItem.Chapters) > 0 || podcastItem.DownloadPath == "" {
+		return nil
+	}
+
+	tag, err := id3.ReadFile(podcastItem.DownloadPath)
+	if err != nil {
+		return err
+	}
+
+	var chapters []db.Chapter
+	for _, chapter := range tag.Chapters {
+		chapters = append(chapters, db.Chapter{
+			StartTime: chapter.StartTime.Seconds(),
+			EndTime:   chapter.EndTime.Seconds(),
+			Title:     chapter.Title,
+			URL:       chapter.URL,
+			Source:    db.ChapterSourceID3,
+		})
+	}
+	if len(chapters) == 0 {
+		return nil
+	}
+	return db.ReplaceChapters(podcastItem.ID, chapters)
+}
+
+func sortedChapters(chapters []db.Chapter) []db.Chapter {
+	sorted := make([]db.Chapter, len(chapters))
+	copy(sorted, chapters)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].StartTime < sorted[j].StartTime
+	})
+	return sorted
+}
+
+// GetChaptersJson returns the chapters of an episode in the JSON chapters
+// format of the Podcasting 2.0 namespace.
+func GetChaptersJson(podcastItem *db.PodcastItem) model.ChaptersJson {
+	toReturn := model.ChaptersJson{
+		Version:  chaptersJsonVersion,
+		Chapters: []model.ChapterJson{},
+	}
+	for _, chapter := range sortedChapters(podcastItem.Chapters) {
+		toReturn.Chapters = append(toReturn.Chapters, model.ChapterJson{
+			StartTime: chapter.StartTime,
+			EndTime:   chapter.EndTime,
+			Title:     chapter.Title,
+			Img:       chapter.Image,
+			URL:       chapter.URL,
+		})
+	}
+	return toReturn
+}
+
+// GetPscChapters returns the chapters of an episode as Podlove simple chapters.
+func GetPscChapters(podcastItem *db.PodcastItem) *model.RssItemPscChapters {
+	if len(podcastItem.Chapters) == 0 {
+		return nil
+	}
+	toReturn := &model.RssItemPscChapters{Version: "1.2"}
+	for _, chapter := range sortedChapters(podcastItem.Chapters) {
+		toReturn.Chapter = append(toReturn.Chapter, model.RssItemPscChapter{
+			Start: formatChapterTime(chapter.StartTime),
+			Title: chapter.Title,
+			Href:  chapter.URL,
+			Image: chapter.Image,
+		})
+	}
+	return toReturn
+}
+
+// parseChapterTime parses a Podlove normal play time such as "01:02:03.500",
+// "02:03" or "123.5" into seconds.
+func parseChapterTime(raw string) (float64, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, false
+	}
+	parts := strings.Split(raw, ":")
+	if len(parts) > 3 {
+		return 0, false
+	}
+	var total float64
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 {
+			return 0, false
+		}
+		total = total*60 + value
+	}
+	return total, true
+}
+
+func formatChapterTime(seconds float64) string {
+	millis := int64(seconds*1000 + 0.5)
+	hours := millis / 3600000
+	millis -= hours * 3600000
+	minutes := millis / 60000
+	millis -= minutes * 60000
+	secs := millis / 1000
+	millis -= secs * 1000
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", hours, minutes, secs, millis)
+}
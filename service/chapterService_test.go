@@ -0,0 +1,54 @@
+package service
+
+import "testing"
+
+func TestParseChapterTime(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want float64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"90", 90, true},
+		{"12.5", 12.5, true},
+		{"01:30", 90, true},
+		{"1:02:03.250", 3723.25, true},
+		{" 00:00:05 ", 5, true},
+		{"", 0, false},
+		{"1:2:3:4", 0, false},
+		{"1:-2", 0, false},
+		{"1:x", 0, false},
+		{"1::2", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parseChapterTime(test.raw)
+		if ok != test.ok || got != test.want {
+			t.Errorf("parseChapterTime(%q) = %v, %v, want %v, %v", test.raw, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestFormatChapterTime(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{0, "00:00:00.000"},
+		{5, "00:00:05.000"},
+		{90.5, "00:01:30.500"},
+		{3723.25, "01:02:03.250"},
+		{59.9996, "00:01:00.000"},
+		{36000, "10:00:00.000"},
+	}
+	for _, test := range tests {
+		formatted := formatChapterTime(test.seconds)
+		if formatted != test.want {
+			t.Errorf("formatChapterTime(%v) = %q, want %q", test.seconds, formatted, test.want)
+		}
+		// Formatted times are read back to the millisecond.
+		parsed, ok := parseChapterTime(formatted)
+		if !ok || formatChapterTime(parsed) != formatted {
+			t.Errorf("parseChapterTime(%q) = %v, %v, which formats back to %q", formatted, parsed, ok, formatChapterTime(parsed))
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/model"
+	pkgErrors "github.com/pkg/errors"
+)
+
+const redacted = "REDACTED"
+
+var (
+	credentialsKey     []byte
+	credentialsKeyErr  error
+	credentialsKeyOnce sync.Once
+
+	// Query parameters commonly carrying the token of a private feed.
+	sensitiveParam = regexp.MustCompile(`(?i)(token|auth|key|secret|pass|sig|session|hash|code)`)
+	// Path segments which look like a token rather than a name.
+	tokenSegment = regexp.MustCompile(`^[A-Za-z0-9_\-]{20,}$`)
+	hasLetter    = regexp.MustCompile(`[A-Za-z]`)
+	hasDigit     = regexp.MustCompile(`[0-9]`)
+)
+
+// getCredentialsKey returns the key credentials are encrypted with. It is
+// derived from CREDENTIALS_KEY when set, otherwise a random key is generated
+// once and kept in the config folder, next to but outside of the database.
+func getCredentialsKey() ([]byte, error) {
+	credentialsKeyOnce.Do(func() {
+		if secret := os.Getenv("CREDENTIALS_KEY"); secret != "" {
+			sum := sha256.Sum256([]byte(secret))
+			credentialsKey = sum[:]
+			return
+		}
+
+		keyPath := path.Join(os.Getenv("CONFIG"), "credentials.key")
+		key, err := os.ReadFile(keyPath)
+		if err == nil && len(key) == 32 {
+			credentialsKey = key
+			return
+		}
+		if err != nil && !os.IsNotExist(err) {
+			credentialsKeyErr = pkgErrors.Wrap(err, "failed to read credentials key")
+			return
+		}
+
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			credentialsKeyErr = pkgErrors.Wrap(err, "failed to generate credentials key")
+			return
+		}
+		if err := os.WriteFile(keyPath, key, 0600); err != nil {
+			credentialsKeyErr = pkgErrors.Wrap(err, "failed to save credentials key")
+			return
+		}
+		credentialsKey = key
+	})
+	return credentialsKey, credentialsKeyErr
+}
+
+func newCredentialsCipher() (cipher.AEAD, error) {
+	key, err := getCredentialsKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptCredentials seals the credentials with AES-GCM. Empty credentials
+// are stored as an empty string.
+func encryptCredentials(credentials *model.FeedCredentials) (string, error) {
+	if credentials.IsEmpty() {
+		return "", nil
+	}
+	plain, err := json.Marshal(credentials)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newCredentialsCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func decryptCredentials(encrypted string) (*model.FeedCredentials, error) {
+	if encrypted == "" {
+		return nil, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "failed to decode credentials")
+	}
+	aead, err := newCredentialsCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("credentials are too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "failed to decrypt credentials, was the key changed?")
+	}
+	var credentials model.FeedCredentials
+	err = json.Unmarshal(plain, &credentials)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "failed to parse credentials")
+	}
+	return &credentials, nil
+}
+
+// getPodcastCredentials returns the credentials of the podcast, or nil when
+// it has none or they cannot be read.
+func getPodcastCredentials(podcast *db.Podcast) *model.FeedCredentials {
+	if podcast == nil || podcast.Credentials == "" {
+		return nil
+	}
+	credentials, err := decryptCredentials(podcast.Credentials)
+	if err != nil {
+		Logger.Errorw("Error reading credentials of "+podcast.Title, err)
+		return nil
+	}
+	return credentials
+}
+
+// SetPodcastCredentials replaces the credentials of a podcast. Empty
+// credentials remove them.
+func SetPodcastCredentials(id string, credentials *model.FeedCredentials) error {
+	var podcast db.Podcast
+	err := db.GetPodcastById(id, &podcast)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encryptCredentials(credentials)
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to encrypt credentials")
+	}
+	return db.UpdatePodcastCredentials(podcast.ID, encrypted, encrypted != "")
+}
+
+// applyCredentials adds the credentials to the request. Clients sending it
+// must drop them on redirects with dropCredentialsOnRedirect.
+func applyCredentials(req *http.Request, credentials *model.FeedCredentials) {
+	if credentials.IsEmpty() {
+		return
+	}
+	if credentials.Username != "" || credentials.Password != "" {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+	for name, value := range credentials.Headers {
+		req.Header.Set(name, value)
+	}
+	for name, value := range credentials.Cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+}
+
+// dropCredentialsOnRedirect removes the credentials from a redirect to another
+// host than the one of the original request. Go only drops the
+// Authorization, WWW-Authenticate and Cookie headers, and only when the
+// redirect leaves the domain, while custom headers like API keys would be
+// sent to the trackers and CDNs feeds and enclosures redirect through.
+func dropCredentialsOnRedirect(req *http.Request, via []*http.Request, credentials *model.FeedCredentials) {
+	if credentials.IsEmpty() || len(via) == 0 || strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		return
+	}
+	for name := range credentials.Headers {
+		req.Header.Del(name)
+	}
+	if credentials.Username != "" || credentials.Password != "" {
+		req.Header.Del("Authorization")
+	}
+	if len(credentials.Cookies) > 0 {
+		req.Header.Del("Cookie")
+	}
+}
+
+// RedactURL hides what looks like a secret in a URL, the password of its
+// user info, token-like query parameters and path segments, so that it can
+// be logged.
+func RedactURL(rawURL string) string {
+	return redactURL(rawURL, true)
+}
+
+// redactPodcastURL redacts the feed URL of a podcast for exports. Path
+// segments are only redacted for private podcasts, as public feeds often
+// have identifiers in their path.
+func redactPodcastURL(podcast *db.Podcast) string {
+	return redactURL(podcast.URL, podcast.IsPrivate || podcast.HasCredentials)
+}
+
+func redactURL(rawURL string, tokenSegments bool) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+
+	if _, hasPassword := parsed.User.Password(); hasPassword {
+		parsed.User = url.UserPassword(parsed.User.Username(), redacted)
+	}
+
+	if tokenSegments {
+		segments := strings.Split(parsed.Path, "/")
+		for i, segment := range segments {
+			name := strings.TrimSuffix(segment, path.Ext(segment))
+			if tokenSegment.MatchString(name) && hasLetter.MatchString(name) && hasDigit.MatchString(name) {
+				segments[i] = redacted + path.Ext(segment)
+			}
+		}
+		parsed.Path = strings.Join(segments, "/")
+		parsed.RawPath = ""
+	}
+
+	query := parsed.Query()
+	changed := false
+	for name := range query {
+		if sensitiveParam.MatchString(name) {
+			query.Set(name, redacted)
+			changed = true
+		}
+	}
+	if changed {
+		parsed.RawQuery = query.Encode()
+	}
+
+	return parsed.String()
+}
+
+// redactError hides secrets of the URL Go includes in request errors.
+func redactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = RedactURL(urlErr.URL)
+	}
+	return err
+}
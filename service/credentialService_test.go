@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/akhilrex/podgrab/model"
+)
+
+func TestDropCredentialsOnRedirect(t *testing.T) {
+	credentials := &model.FeedCredentials{
+		Username: "user",
+		Password: "secret",
+		Headers:  map[string]string{"X-Api-Key": "key"},
+		Cookies:  map[string]string{"session": "cookie"},
+	}
+
+	var received http.Header
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer target.Close()
+
+	var origin *httptest.Server
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/away":
+			http.Redirect(w, r, target.URL+"/file", http.StatusFound)
+		case "/here":
+			http.Redirect(w, r, origin.URL+"/file", http.StatusFound)
+		default:
+			received = r.Header.Clone()
+		}
+	}))
+	defer origin.Close()
+
+	tests := []struct {
+		path      string
+		keepsAuth bool
+	}{
+		{"/away", false},
+		{"/here", true},
+	}
+	for _, test := range tests {
+		received = nil
+		req, err := http.NewRequest(http.MethodGet, origin.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		applyCredentials(req, credentials)
+		resp, err := httpClient(credentials).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		for _, name := range []string{"X-Api-Key", "Authorization", "Cookie"} {
+			if sent := received.Get(name) != ""; sent != test.keepsAuth {
+				t.Errorf("redirect %s: %s sent = %v, want %v", test.path, name, sent, test.keepsAuth)
+			}
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+)
+
+// Priorities of download jobs. Jobs of a higher priority start first, jobs of
+// the same priority in the order they were queued.
+const (
+	DownloadPriorityAuto   = 0
+	DownloadPriorityManual = 10
+)
+
+// Number of finished jobs kept to be listed.
+const finishedDownloadsKept = 100
+
+type DownloadJobStatus string
+
+const (
+	DownloadJobQueued    DownloadJobStatus = "queued"
+	DownloadJobActive    DownloadJobStatus = "active"
+	DownloadJobCompleted DownloadJobStatus = "completed"
+	DownloadJobFailed    DownloadJobStatus = "failed"
+	DownloadJobSkipped   DownloadJobStatus = "skipped"
+)
+
+// DownloadJob is the download of an episode by the download queue. While it
+// is active, BytesTransferred counts what was saved so far, including what a
+// previous attempt left to resume from, out of TotalBytes, 0 when the size is
+// unknown. Speed is in bytes per second and ETA in seconds, both 0 until
+// known.
+type DownloadJob struct {
+	PodcastItemID string            `json:"podcastItemId"`
+	Title         string            `json:"title"`
+	PodcastTitle  string            `json:"podcastTitle"`
+	Priority      int               `json:"priority"`
+	Status        DownloadJobStatus `json:"status"`
+	Error         string            `json:"error,omitempty"`
+	QueuedAt      time.Time         `json:"queuedAt"`
+	StartedAt     *time.Time        `json:"startedAt,omitempty"`
+	FinishedAt    *time.Time        `json:"finishedAt,omitempty"`
+
+	BytesTransferred int64 `json:"bytesTransferred"`
+	TotalBytes       int64 `json:"totalBytes"`
+	Speed            int64 `json:"speed"`
+	ETA              int64 `json:"eta"`
+}
+
+// DownloadQueueState lists the jobs of the download queue, queued ones in the
+// order they will start and finished ones from the most recent.
+type DownloadQueueState struct {
+	Workers  int           `json:"workers"`
+	Queued   []DownloadJob `json:"queued"`
+	Active   []DownloadJob `json:"active"`
+	Finished []DownloadJob `json:"finished"`
+}
+
+// downloadQueue runs every episode download of the application, at most
+// workers at once. An episode is only queued once: queuing it again while it
+// waits can only raise its priority, and is ignored while it downloads.
+type downloadQueue struct {
+	mu       sync.Mutex
+	workers  int
+	sequence int64
+	queued   []*queuedDownload
+	jobs     map[string]*queuedDownload
+	active   int
+	finished []DownloadJob
+	run      func(job *DownloadJob, progress downloadProgress) error
+	listener func(job DownloadJob)
+}
+
+type queuedDownload struct {
+	job DownloadJob
+	// sequence orders jobs of the same priority.
+	sequence int64
+	// Last measure of the bytes transferred, the speed is computed from.
+	sampleBytes int64
+	sampleTime  time.Time
+}
+
+// Shortest interval the speed of a download is measured over, and weight of
+// the latest measure in the speed reported.
+const (
+	downloadSpeedInterval = time.Second
+	downloadSpeedWeight   = 0.3
+)
+
+var (
+	sharedDownloadQueue     *downloadQueue
+	sharedDownloadQueueOnce sync.Once
+)
+
+// getDownloadQueue returns the queue shared by all downloads, with as many
+// workers as the MaxDownloadConcurrency setting.
+func getDownloadQueue() *downloadQueue {
+	sharedDownloadQueueOnce.Do(func() {
+		sharedDownloadQueue = newDownloadQueue(db.GetOrCreateSetting().MaxDownloadConcurrency, downloadQueuedEpisode)
+	})
+	return sharedDownloadQueue
+}
+
+func newDownloadQueue(workers int, run func(job *DownloadJob, progress downloadProgress) error) *downloadQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &downloadQueue{
+		workers: workers,
+		jobs:    make(map[string]*queuedDownload),
+		run:     run,
+	}
+}
+
+// Enqueue queues the download of an episode. It tells whether a job was
+// added, rather than an existing one being kept.
+func (q *downloadQueue) Enqueue(podcastItem *db.PodcastItem, priority int) bool {
+	q.mu.Lock()
+	if existing, ok := q.jobs[podcastItem.ID]; ok {
+		if existing.job.Status == DownloadJobQueued && priority > existing.job.Priority {
+			existing.job.Priority = priority
+			q.sortQueued()
+		}
+		q.mu.Unlock()
+		return false
+	}
+
+	q.sequence++
+	queued := &queuedDownload{
+		job: DownloadJob{
+			PodcastItemID: podcastItem.ID,
+			Title:         podcastItem.Title,
+			PodcastTitle:  podcastItem.Podcast.Title,
+			Priority:      priority,
+			Status:        DownloadJobQueued,
+			QueuedAt:      time.Now(),
+		},
+		sequence: q.sequence,
+	}
+	q.jobs[podcastItem.ID] = queued
+	q.queued = append(q.queued, queued)
+	q.sortQueued()
+	job := queued.job
+	q.dispatch()
+	q.mu.Unlock()
+
+	q.notify(job)
+	return true
+}
+
+// SetListener sets the function told about every change of a job: when it
+// is queued, started, makes progress and finishes. It is called from the
+// goroutines running the downloads.
+func (q *downloadQueue) SetListener(listener func(job DownloadJob)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.listener = listener
+}
+
+func (q *downloadQueue) notify(job DownloadJob) {
+	q.mu.Lock()
+	listener := q.listener
+	q.mu.Unlock()
+	if listener != nil {
+		listener(job)
+	}
+}
+
+// SetWorkers changes the number of downloads run at once. Running downloads
+// are not interrupted when it shrinks.
+func (q *downloadQueue) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.workers = workers
+	q.dispatch()
+}
+
+// State returns a copy of the jobs of the queue.
+func (q *downloadQueue) State() DownloadQueueState {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	state := DownloadQueueState{
+		Workers:  q.workers,
+		Queued:   []DownloadJob{},
+		Active:   []DownloadJob{},
+		Finished: []DownloadJob{},
+	}
+	for _, queued := range q.queued {
+		state.Queued = append(state.Queued, queued.job)
+	}
+	for _, queued := range q.jobs {
+		if queued.job.Status == DownloadJobActive {
+			state.Active = append(state.Active, queued.job)
+		}
+	}
+	sort.Slice(state.Active, func(i, j int) bool {
+		return state.Active[i].StartedAt.Before(*state.Active[j].StartedAt)
+	})
+	for i := len(q.finished) - 1; i >= 0; i-- {
+		state.Finished = append(state.Finished, q.finished[i])
+	}
+	return state
+}
+
+func (q *downloadQueue) sortQueued() {
+	sort.SliceStable(q.queued, func(i, j int) bool {
+		if q.queued[i].job.Priority != q.queued[j].job.Priority {
+			return q.queued[i].job.Priority > q.queued[j].job.Priority
+		}
+		return q.queued[i].sequence < q.queued[j].sequence
+	})
+}
+
+// dispatch starts queued jobs while workers are free. It is called with the
+// lock held.
+func (q *downloadQueue) dispatch() {
+	for q.active < q.workers && len(q.queued) > 0 {
+		queued := q.queued[0]
+		q.queued = q.queued[1:]
+		now := time.Now()
+		queued.job.Status = DownloadJobActive
+		queued.job.StartedAt = &now
+		q.active++
+		go q.work(queued)
+	}
+}
+
+func (q *downloadQueue) work(queued *queuedDownload) {
+	q.mu.Lock()
+	job := queued.job
+	q.mu.Unlock()
+	q.notify(job)
+
+	err := q.run(&job, func(transferred int64, total int64) {
+		q.notify(q.updateProgress(queued, transferred, total))
+	})
+
+	q.mu.Lock()
+	now := time.Now()
+	queued.job.FinishedAt = &now
+	queued.job.Speed = 0
+	queued.job.ETA = 0
+	if err != nil {
+		queued.job.Status = DownloadJobFailed
+		queued.job.Error = redactError(err).Error()
+	} else if job.Status == DownloadJobSkipped {
+		queued.job.Status = DownloadJobSkipped
+	} else {
+		queued.job.Status = DownloadJobCompleted
+	}
+	job = queued.job
+	delete(q.jobs, job.PodcastItemID)
+	q.finished = append(q.finished, job)
+	if len(q.finished) > finishedDownloadsKept {
+		q.finished = q.finished[len(q.finished)-finishedDownloadsKept:]
+	}
+	q.active--
+	q.dispatch()
+	q.mu.Unlock()
+
+	q.notify(job)
+}
+
+// updateProgress records the progress of an active job and returns a copy of
+// it. The speed is smoothed over the successive measures so that the ETA
+// does not jump around.
+func (q *downloadQueue) updateProgress(queued *queuedDownload, transferred int64, total int64) DownloadJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	queued.job.BytesTransferred = transferred
+	queued.job.TotalBytes = total
+	if queued.sampleTime.IsZero() || transferred < queued.sampleBytes {
+		queued.sampleBytes = transferred
+		queued.sampleTime = now
+	} else if elapsed := now.Sub(queued.sampleTime); elapsed >= downloadSpeedInterval {
+		speed := float64(transferred-queued.sampleBytes) / elapsed.Seconds()
+		if queued.job.Speed > 0 {
+			speed = downloadSpeedWeight*speed + (1-downloadSpeedWeight)*float64(queued.job.Speed)
+		}
+		queued.job.Speed = int64(speed)
+		queued.sampleBytes = transferred
+		queued.sampleTime = now
+	}
+	queued.job.ETA = 0
+	if queued.job.Speed > 0 && total > transferred {
+		queued.job.ETA = (total - transferred) / queued.job.Speed
+	}
+	return queued.job
+}
+
+// EnqueueDownload queues the download of an episode.
+func EnqueueDownload(podcastItem *db.PodcastItem, priority int) bool {
+	return getDownloadQueue().Enqueue(podcastItem, priority)
+}
+
+// SetDownloadListener sets the function told about every change of a
+// download, like its progress.
+func SetDownloadListener(listener func(job DownloadJob)) {
+	getDownloadQueue().SetListener(listener)
+}
+
+// GetActiveDownloads returns the downloads running, with their progress.
+func GetActiveDownloads() []DownloadJob {
+	return getDownloadQueue().State().Active
+}
+
+// GetDownloadQueueState returns the queued, running and recently finished
+// downloads.
+func GetDownloadQueueState() DownloadQueueState {
+	return getDownloadQueue().State()
+}
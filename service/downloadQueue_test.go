@@ -0,0 +1,175 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+)
+
+// testDownloadQueue is a download queue whose jobs each wait to be released,
+// reporting when they start.
+type testDownloadQueue struct {
+	*downloadQueue
+	started chan string
+	release chan error
+}
+
+func newTestDownloadQueue(workers int) *testDownloadQueue {
+	q := &testDownloadQueue{
+		started: make(chan string, 10),
+		release: make(chan error),
+	}
+	q.downloadQueue = newDownloadQueue(workers, func(job *DownloadJob, progress downloadProgress) error {
+		q.started <- job.PodcastItemID
+		err := <-q.release
+		if err == errSkipTestDownload {
+			job.Status = DownloadJobSkipped
+			return nil
+		}
+		return err
+	})
+	return q
+}
+
+var errSkipTestDownload = errors.New("skip")
+
+func (q *testDownloadQueue) enqueue(id string, priority int) bool {
+	return q.Enqueue(&db.PodcastItem{Base: db.Base{ID: id}, Title: "Episode " + id}, priority)
+}
+
+func (q *testDownloadQueue) waitStarted(t *testing.T) string {
+	t.Helper()
+	select {
+	case id := <-q.started:
+		return id
+	case <-time.After(5 * time.Second):
+		t.Fatal("no download started")
+		return ""
+	}
+}
+
+func (q *testDownloadQueue) queuedIDs() []string {
+	ids := []string{}
+	for _, job := range q.State().Queued {
+		ids = append(ids, job.PodcastItemID)
+	}
+	return ids
+}
+
+func TestDownloadQueueOrder(t *testing.T) {
+	q := newTestDownloadQueue(1)
+
+	if !q.enqueue("a", DownloadPriorityAuto) {
+		t.Fatal("Enqueue(a) = false, want true")
+	}
+	if id := q.waitStarted(t); id != "a" {
+		t.Fatalf("started %s, want a", id)
+	}
+
+	steps := []struct {
+		id       string
+		priority int
+		added    bool
+		queued   []string
+	}{
+		{"b", DownloadPriorityAuto, true, []string{"b"}},
+		{"c", DownloadPriorityAuto, true, []string{"b", "c"}},
+		{"d", DownloadPriorityManual, true, []string{"d", "b", "c"}},
+		{"e", DownloadPriorityAuto, true, []string{"d", "b", "c", "e"}},
+		// Queuing a waiting episode again can only raise its priority. It
+		// keeps its place among the episodes of that priority.
+		{"c", DownloadPriorityManual, false, []string{"c", "d", "b", "e"}},
+		{"d", DownloadPriorityAuto, false, []string{"c", "d", "b", "e"}},
+		{"b", DownloadPriorityAuto, false, []string{"c", "d", "b", "e"}},
+		// A running episode is not queued again.
+		{"a", DownloadPriorityManual, false, []string{"c", "d", "b", "e"}},
+	}
+	for _, step := range steps {
+		if added := q.enqueue(step.id, step.priority); added != step.added {
+			t.Errorf("Enqueue(%s, %d) = %v, want %v", step.id, step.priority, added, step.added)
+		}
+		if queued := q.queuedIDs(); !reflect.DeepEqual(queued, step.queued) {
+			t.Errorf("after Enqueue(%s, %d) queued %v, want %v", step.id, step.priority, queued, step.queued)
+		}
+	}
+
+	state := q.State()
+	if len(state.Active) != 1 || state.Active[0].PodcastItemID != "a" || state.Active[0].Priority != DownloadPriorityAuto {
+		t.Errorf("active %+v, want a with the auto priority", state.Active)
+	}
+
+	results := map[string]error{"a": nil, "c": errSkipTestDownload, "d": errors.New("failed"), "b": nil}
+	for _, want := range []string{"c", "d", "b", "e"} {
+		q.release <- results[q.State().Active[0].PodcastItemID]
+		if id := q.waitStarted(t); id != want {
+			t.Fatalf("started %s, want %s", id, want)
+		}
+	}
+	q.release <- nil
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(q.State().Finished) < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	state = q.State()
+	want := []struct {
+		id     string
+		status DownloadJobStatus
+	}{
+		{"e", DownloadJobCompleted},
+		{"b", DownloadJobCompleted},
+		{"d", DownloadJobFailed},
+		{"c", DownloadJobSkipped},
+		{"a", DownloadJobCompleted},
+	}
+	if len(state.Finished) != len(want) {
+		t.Fatalf("finished %+v, want %d jobs", state.Finished, len(want))
+	}
+	for i, job := range state.Finished {
+		if job.PodcastItemID != want[i].id || job.Status != want[i].status {
+			t.Errorf("finished[%d] = %s %s, want %s %s", i, job.PodcastItemID, job.Status, want[i].id, want[i].status)
+		}
+	}
+	if state.Finished[2].Error != "failed" {
+		t.Errorf("error of d = %q, want %q", state.Finished[2].Error, "failed")
+	}
+	if len(state.Queued) != 0 || len(state.Active) != 0 {
+		t.Errorf("queued %v and active %v, want none", state.Queued, state.Active)
+	}
+
+	// Finished episodes can be queued again.
+	if !q.enqueue("a", DownloadPriorityAuto) {
+		t.Error("Enqueue(a) after it finished = false, want true")
+	}
+	q.waitStarted(t)
+	q.release <- nil
+}
+
+func TestDownloadQueueWorkers(t *testing.T) {
+	q := newTestDownloadQueue(2)
+	for _, id := range []string{"a", "b", "c"} {
+		q.enqueue(id, DownloadPriorityAuto)
+	}
+	started := map[string]bool{q.waitStarted(t): true, q.waitStarted(t): true}
+	if !started["a"] || !started["b"] {
+		t.Fatalf("started %v, want a and b", started)
+	}
+	if queued := q.queuedIDs(); !reflect.DeepEqual(queued, []string{"c"}) {
+		t.Errorf("queued %v, want [c]", queued)
+	}
+
+	// A third worker starts the queued download right away.
+	q.SetWorkers(3)
+	if id := q.waitStarted(t); id != "c" {
+		t.Errorf("started %s, want c", id)
+	}
+	if active := len(q.State().Active); active != 3 {
+		t.Errorf("%d active downloads, want 3", active)
+	}
+	for i := 0; i < 3; i++ {
+		q.release <- nil
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/model"
+)
+
+// enclosurePreferences decide which encoding of an episode is downloaded.
+type enclosurePreferences struct {
+	Codec string
+	// MaxBitrate is in kbps, 0 for no limit.
+	MaxBitrate int
+	// MediaKind is audio or video, empty for either.
+	MediaKind string
+}
+
+func (preferences enclosurePreferences) isEmpty() bool {
+	return preferences.Codec == "" && preferences.MaxBitrate <= 0 && preferences.MediaKind == ""
+}
+
+// enclosureCandidate is the main enclosure of an episode or one of its
+// alternates.
+type enclosureCandidate struct {
+	URL    string
+	Type   string
+	Codecs string
+	// Length is the size announced by the feed. Size is the one used to
+	// compare candidates, estimated from the bitrate when not announced.
+	// Both are in bytes and Bitrate in bits per second, 0 when unknown.
+	Length  int64
+	Size    int64
+	Bitrate int
+}
+
+var errInvalidMediaKind = errors.New("media kind must be audio or video")
+
+// getEnclosurePreferences returns the preferences of the podcast, falling
+// back to the settings for the ones it does not set.
+func getEnclosurePreferences(podcast *db.Podcast, setting *db.Setting) enclosurePreferences {
+	preferences := enclosurePreferences{
+		Codec:      setting.PreferredCodec,
+		MaxBitrate: setting.MaxBitrate,
+		MediaKind:  setting.PreferredMediaKind,
+	}
+	if podcast.PreferredCodec != "" {
+		preferences.Codec = podcast.PreferredCodec
+	}
+	if podcast.MaxBitrate > 0 {
+		preferences.MaxBitrate = podcast.MaxBitrate
+	}
+	if podcast.PreferredMediaKind != "" {
+		preferences.MediaKind = podcast.PreferredMediaKind
+	}
+	return preferences
+}
+
+// SetPodcastEnclosurePreferences sets the enclosure preferences of a podcast,
+// empty values fall back to the settings.
+func SetPodcastEnclosurePreferences(id string, preferredCodec string, maxBitrate int, preferredMediaKind string) error {
+	preferredMediaKind, err := normalizeMediaKind(preferredMediaKind)
+	if err != nil {
+		return err
+	}
+	if maxBitrate < 0 {
+		maxBitrate = 0
+	}
+	return db.UpdatePodcastEnclosurePreferences(id, strings.ToLower(strings.TrimSpace(preferredCodec)), maxBitrate, preferredMediaKind)
+}
+
+func normalizeMediaKind(mediaKind string) (string, error) {
+	mediaKind = strings.ToLower(strings.TrimSpace(mediaKind))
+	if mediaKind != "" && mediaKind != "audio" && mediaKind != "video" {
+		return "", errInvalidMediaKind
+	}
+	return mediaKind, nil
+}
+
+// selectEnclosure picks the enclosure of the episode to download. Without
+// preferences the main enclosure is always used.
+// Otherwise the enclosures of the preferred kind within the bitrate limit
+// are kept, favouring the preferred codec, and the smallest one wins. A
+// preference no enclosure satisfies is ignored.
+func selectEnclosure(podcastItem *db.PodcastItem, setting *db.Setting) enclosureCandidate {
+	main := enclosureCandidate{
+		URL:    podcastItem.FileURL,
+		Type:   podcastItem.EnclosureType,
+		Length: podcastItem.EnclosureLength,
+		Size:   podcastItem.EnclosureLength,
+	}
+	if main.Size == 0 {
+		main.Size = podcastItem.FileSize
+	}
+	preferences := getEnclosurePreferences(&podcastItem.Podcast, setting)
+	if preferences.isEmpty() || len(podcastItem.AlternateEnclosures) == 0 {
+		return main
+	}
+
+	candidates := []enclosureCandidate{main}
+	for _, alternate := range podcastItem.AlternateEnclosures {
+		candidates = append(candidates, enclosureCandidate{
+			URL:     alternate.URL,
+			Type:    alternate.Type,
+			Codecs:  alternate.Codecs,
+			Length:  alternate.Length,
+			Size:    alternate.Length,
+			Bitrate: alternate.Bitrate,
+		})
+	}
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.Bitrate == 0 && candidate.Size > 0 && podcastItem.Duration > 0 {
+			candidate.Bitrate = int(candidate.Size * 8 / int64(podcastItem.Duration))
+		}
+		if candidate.Size == 0 && candidate.Bitrate > 0 && podcastItem.Duration > 0 {
+			candidate.Size = int64(candidate.Bitrate) * int64(podcastItem.Duration) / 8
+		}
+	}
+
+	if preferences.MediaKind != "" {
+		candidates = keepCandidates(candidates, func(candidate enclosureCandidate) bool {
+			return strings.HasPrefix(strings.ToLower(candidate.Type), preferences.MediaKind+"/")
+		})
+	}
+	if preferences.MaxBitrate > 0 {
+		candidates = keepCandidates(candidates, func(candidate enclosureCandidate) bool {
+			return candidate.Bitrate > 0 && candidate.Bitrate <= preferences.MaxBitrate*1000
+		})
+	}
+	if preferences.Codec != "" {
+		candidates = keepCandidates(candidates, func(candidate enclosureCandidate) bool {
+			return matchesCodec(candidate, preferences.Codec)
+		})
+	}
+
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.Size > 0 && (best.Size == 0 || candidate.Size < best.Size) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// keepCandidates returns the candidates matching keep, or all of them when
+// none does.
+func keepCandidates(candidates []enclosureCandidate, keep func(enclosureCandidate) bool) []enclosureCandidate {
+	var kept []enclosureCandidate
+	for _, candidate := range candidates {
+		if keep(candidate) {
+			kept = append(kept, candidate)
+		}
+	}
+	if len(kept) == 0 {
+		return candidates
+	}
+	return kept
+}
+
+// matchesCodec tells whether the codecs or the media type of the candidate
+// mention the codec, like opus in audio/opus or mp4a in codecs="mp4a.40.2".
+func matchesCodec(candidate enclosureCandidate, codec string) bool {
+	codec = strings.ToLower(codec)
+	if strings.Contains(strings.ToLower(candidate.Codecs), codec) {
+		return true
+	}
+	_, subtype, _ := strings.Cut(strings.ToLower(candidate.Type), "/")
+	return strings.Contains(subtype, codec)
+}
+
+func toAlternateEnclosures(feedEnclosures []model.FeedAlternateEnclosure) []db.AlternateEnclosure {
+	var enclosures []db.AlternateEnclosure
+	for _, feedEnclosure := range feedEnclosures {
+		source := ""
+		for _, candidate := range feedEnclosure.Sources {
+			if parsed, err := url.Parse(strings.TrimSpace(candidate.URI)); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+				source = parsed.String()
+				break
+			}
+		}
+		if source == "" {
+			continue
+		}
+		length, _ := strconv.ParseInt(strings.TrimSpace(feedEnclosure.Length), 10, 64)
+		bitrate, _ := strconv.ParseFloat(strings.TrimSpace(feedEnclosure.Bitrate), 64)
+		height, _ := strconv.Atoi(strings.TrimSpace(feedEnclosure.Height))
+		isDefault, _ := strconv.ParseBool(strings.TrimSpace(feedEnclosure.Default))
+		enclosures = append(enclosures, db.AlternateEnclosure{
+			URL:       source,
+			Type:      strings.TrimSpace(feedEnclosure.Type),
+			Length:    length,
+			Bitrate:   int(math.Round(bitrate)),
+			Height:    height,
+			Codecs:    feedEnclosure.Codecs,
+			Lang:      feedEnclosure.Lang,
+			Title:     feedEnclosure.Title,
+			IsDefault: isDefault,
+		})
+	}
+	return enclosures
+}
+
+// updateAlternateEnclosures records the alternate enclosures of the feed for
+// an existing episode when they changed.
+func updateAlternateEnclosures(podcastItem *db.PodcastItem, feedEnclosures []model.FeedAlternateEnclosure) error {
+	enclosures := toAlternateEnclosures(feedEnclosures)
+	if len(enclosures) == len(podcastItem.AlternateEnclosures) {
+		known := make(map[string]bool)
+		for _, enclosure := range podcastItem.AlternateEnclosures {
+			known[enclosure.URL] = true
+		}
+		changed := false
+		for _, enclosure := range enclosures {
+			if !known[enclosure.URL] {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return nil
+		}
+	}
+	return db.ReplaceAlternateEnclosures(podcastItem.ID, enclosures)
+}
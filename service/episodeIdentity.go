@@ -0,0 +1,208 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/model"
+	pkgErrors "github.com/pkg/errors"
+)
+
+// Prefixes analytics services put in front of enclosure URLs. They come and
+// go as publishers change providers, so they are ignored when comparing
+// enclosures. They may be chained.
+var trackingPrefixes = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^(www\.|dts\.)?podtrac\.com/(pts/)?redirect\.[a-z0-9]+/`),
+	regexp.MustCompile(`(?i)^chtbl\.com/track/[^/]+/`),
+	regexp.MustCompile(`(?i)^chrt\.fm/track/[^/]+/`),
+	regexp.MustCompile(`(?i)^pdst\.fm/e/`),
+	regexp.MustCompile(`(?i)^(www\.)?pscrb\.fm/rss/p/`),
+	regexp.MustCompile(`(?i)^verifi\.podscribe\.com/rss/p/`),
+	regexp.MustCompile(`(?i)^op3\.dev/e(,[^/]*)?/`),
+	regexp.MustCompile(`(?i)^pfx\.vpixl\.com/[^/]+/`),
+	regexp.MustCompile(`(?i)^mgln\.ai/e/[^/]+/`),
+	regexp.MustCompile(`(?i)^prfx\.byspotify\.com/e/`),
+	regexp.MustCompile(`(?i)^arttrk\.com/p/[^/]+/`),
+	regexp.MustCompile(`(?i)^(www\.)?claritaspod\.com/measure/`),
+	regexp.MustCompile(`(?i)^media\.blubrry\.com/[^/]+/`),
+	regexp.MustCompile(`(?i)^tracking\.swap\.fm/track/[^/]+/`),
+}
+
+var urlScheme = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+// normalizeEnclosureURL reduces an enclosure URL to what identifies the file:
+// the scheme, query string and tracking prefixes are dropped and the host is
+// lowercased.
+func normalizeEnclosureURL(raw string) string {
+	value := strings.TrimSpace(raw)
+	if i := strings.IndexAny(value, "?#"); i >= 0 {
+		value = value[:i]
+	}
+	value = urlScheme.ReplaceAllString(value, "")
+	for stripped := true; stripped; {
+		stripped = false
+		for _, prefix := range trackingPrefixes {
+			if loc := prefix.FindStringIndex(value); loc != nil {
+				value = urlScheme.ReplaceAllString(value[loc[1]:], "")
+				stripped = true
+			}
+		}
+	}
+	if value == "" {
+		return ""
+	}
+	host, path, _ := strings.Cut(value, "/")
+	return strings.ToLower(host) + "/" + path
+}
+
+// titleDateKey identifies an episode by its title and the day it was
+// published. It is empty when either is missing.
+func titleDateKey(title string, pubDate time.Time) string {
+	title = strings.ToLower(strings.Join(strings.Fields(title), " "))
+	if title == "" || (pubDate == time.Time{}) {
+		return ""
+	}
+	return title + "|" + pubDate.UTC().Format("2006-01-02")
+}
+
+// fallbackGUID gives items without a GUID an identity, the normalized
+// enclosure URL or else their title and publication date.
+func fallbackGUID(item *model.FeedItem) string {
+	if key := normalizeEnclosureURL(item.Enclosure.URL); key != "" {
+		return key
+	}
+	if item.Title == "" && item.PubDate == "" {
+		return ""
+	}
+	return strings.TrimSpace(item.Title) + "|" + strings.TrimSpace(item.PubDate)
+}
+
+// linkRegeneratedItems finds the existing episodes of feed items whose GUID is
+// unknown, first by enclosure URL then by title and publication date, and
+// moves them to the new GUID so they are not created, and downloaded, again.
+// Only episodes whose own GUID has left the feed are considered. Matched
+// episodes are added to keyMap.
+func linkRegeneratedItems(podcast *db.Podcast, items []model.FeedItem, guids []string, keyMap map[string]db.PodcastItem) error {
+	var unmatched []model.FeedItem
+	for _, item := range items {
+		if _, ok := keyMap[item.GUID]; !ok {
+			unmatched = append(unmatched, item)
+		}
+	}
+	if len(unmatched) == 0 {
+		return nil
+	}
+
+	candidates, err := db.GetPodcastItemsByPodcastIdNotInGUIDs(podcast.ID, guids)
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to get podcast items missing from the feed")
+	}
+	if len(*candidates) == 0 {
+		return nil
+	}
+	byEnclosure := make(map[string]db.PodcastItem)
+	byTitleDate := make(map[string]db.PodcastItem)
+	for _, candidate := range *candidates {
+		if key := normalizeEnclosureURL(candidate.FileURL); key != "" {
+			byEnclosure[key] = candidate
+		}
+		if key := titleDateKey(candidate.Title, candidate.PubDate); key != "" {
+			byTitleDate[key] = candidate
+		}
+	}
+
+	linked := make(map[string]bool)
+	for _, item := range unmatched {
+		if item.GUID == "" {
+			continue
+		}
+		existing, ok := byEnclosure[normalizeEnclosureURL(item.Enclosure.URL)]
+		if !ok || linked[existing.ID] {
+			pubDate, _ := ParsePubDate(item.PubDate)
+			existing, ok = byTitleDate[titleDateKey(item.Title, pubDate)]
+		}
+		if !ok || linked[existing.ID] {
+			continue
+		}
+
+		err := db.UpdatePodcastItemGUID(existing.ID, item.GUID)
+		if err != nil {
+			return pkgErrors.Wrap(err, "failed to update podcast item guid")
+		}
+		fmt.Println("Linked regenerated GUID of " + existing.Title)
+		linked[existing.ID] = true
+		existing.GUID = item.GUID
+		keyMap[item.GUID] = existing
+	}
+	return nil
+}
+
+// DuplicateEpisodes is a group of episodes of a podcast which look like the
+// same episode.
+type DuplicateEpisodes struct {
+	Reason string
+	Items  []db.PodcastItem
+}
+
+// GetSuspectedDuplicates lists the episodes sharing an enclosure, or a title
+// and publication day, within a podcast. All podcasts are checked when
+// podcastId is empty.
+func GetSuspectedDuplicates(podcastId string) ([]DuplicateEpisodes, error) {
+	var podcastItems []db.PodcastItem
+	var err error
+	if podcastId == "" {
+		err = db.GetAllPodcastItems(&podcastItems)
+	} else {
+		err = db.GetAllPodcastItemsByPodcastId(podcastId, &podcastItems)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	byEnclosure := make(map[string][]db.PodcastItem)
+	byTitleDate := make(map[string][]db.PodcastItem)
+	for _, item := range podcastItems {
+		if key := normalizeEnclosureURL(item.FileURL); key != "" {
+			key = item.PodcastID + "|" + key
+			byEnclosure[key] = append(byEnclosure[key], item)
+		}
+		if key := titleDateKey(item.Title, item.PubDate); key != "" {
+			key = item.PodcastID + "|" + key
+			byTitleDate[key] = append(byTitleDate[key], item)
+		}
+	}
+
+	duplicates := []DuplicateEpisodes{}
+	reported := make(map[string]bool)
+	add := func(reason string, groups map[string][]db.PodcastItem) {
+		keys := make([]string, 0, len(groups))
+		for key := range groups {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			items := groups[key]
+			if len(items) < 2 {
+				continue
+			}
+			ids := make([]string, len(items))
+			for i, item := range items {
+				ids[i] = item.ID
+			}
+			sort.Strings(ids)
+			signature := strings.Join(ids, ",")
+			if reported[signature] {
+				continue
+			}
+			reported[signature] = true
+			duplicates = append(duplicates, DuplicateEpisodes{Reason: reason, Items: items})
+		}
+	}
+	add("Same enclosure", byEnclosure)
+	add("Same title and date", byTitleDate)
+	return duplicates, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/akhilrex/podgrab/model"
+)
+
+func TestNormalizeEnclosureURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"", ""},
+		{"  ", ""},
+		{"https://example.com/episode.mp3", "example.com/episode.mp3"},
+		{"http://Example.COM/Episode.mp3", "example.com/Episode.mp3"},
+		{" https://example.com/episode.mp3?source=rss#t=10 ", "example.com/episode.mp3"},
+		{"example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://dts.podtrac.com/redirect.mp3/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://www.podtrac.com/pts/redirect.mp3/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://chtbl.com/track/ABC123/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://chrt.fm/track/ABC123/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://pdst.fm/e/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://pscrb.fm/rss/p/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://verifi.podscribe.com/rss/p/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://op3.dev/e/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://op3.dev/e,pg=123/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://pfx.vpixl.com/abc/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://mgln.ai/e/123/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://prfx.byspotify.com/e/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://arttrk.com/p/ABCD/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://claritaspod.com/measure/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://media.blubrry.com/show/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://tracking.swap.fm/track/abc/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://DTS.Podtrac.com/redirect.mp3/example.com/episode.mp3", "example.com/episode.mp3"},
+		// Prefixes may keep the scheme of the URL they wrap, and be chained.
+		{"https://pdst.fm/e/https://example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://dts.podtrac.com/redirect.mp3/chtbl.com/track/ABC/pdst.fm/e/example.com/episode.mp3", "example.com/episode.mp3"},
+		{"https://chtbl.com/track/ABC/https://op3.dev/e/https://Example.com/episode.mp3?x=1", "example.com/episode.mp3"},
+		// Lookalike hosts and paths are not tracking prefixes.
+		{"https://notpodtrac.com/redirect.mp3/example.com/episode.mp3", "notpodtrac.com/redirect.mp3/example.com/episode.mp3"},
+		{"https://example.com/chtbl.com/track/ABC/episode.mp3", "example.com/chtbl.com/track/ABC/episode.mp3"},
+	}
+	for _, test := range tests {
+		if got := normalizeEnclosureURL(test.url); got != test.want {
+			t.Errorf("normalizeEnclosureURL(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestFallbackGUID(t *testing.T) {
+	tests := []struct {
+		name string
+		item model.FeedItem
+		want string
+	}{
+		{
+			name: "enclosure",
+			item: model.FeedItem{
+				Title:     "Episode",
+				PubDate:   "Mon, 01 Jan 2024 10:00:00 GMT",
+				Enclosure: model.FeedEnclosure{URL: "https://chtbl.com/track/ABC/Example.com/episode.mp3?source=rss"},
+			},
+			want: "example.com/episode.mp3",
+		},
+		{
+			name: "title and date",
+			item: model.FeedItem{Title: " Episode ", PubDate: " Mon, 01 Jan 2024 10:00:00 GMT "},
+			want: "Episode|Mon, 01 Jan 2024 10:00:00 GMT",
+		},
+		{
+			name: "title only",
+			item: model.FeedItem{Title: "Episode"},
+			want: "Episode|",
+		},
+		{
+			name: "nothing to identify the item",
+			item: model.FeedItem{Summary: "Summary"},
+			want: "",
+		},
+	}
+	for _, test := range tests {
+		if got := fallbackGUID(&test.item); got != test.want {
+			t.Errorf("%s: fallbackGUID() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTitleDateKey(t *testing.T) {
+	pubDate := time.Date(2024, 1, 1, 23, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+	tests := []struct {
+		title   string
+		pubDate time.Time
+		want    string
+	}{
+		{"  Episode   One ", pubDate, "episode one|2024-01-02"},
+		{"EPISODE ONE", pubDate.UTC(), "episode one|2024-01-02"},
+		{"", pubDate, ""},
+		{"Episode", time.Time{}, ""},
+	}
+	for _, test := range tests {
+		if got := titleDateKey(test.title, test.pubDate); got != test.want {
+			t.Errorf("titleDateKey(%q, %v) = %q, want %q", test.title, test.pubDate, got, test.want)
+		}
+	}
+}
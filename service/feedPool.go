@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFeedFetchWorkers = 5
+	defaultFeedFetchPerHost = 2
+	defaultFeedFetchTimeout = 30 * time.Second
+)
+
+// feedTask is a single fetch run by the feed pool. URL is only used to
+// apply the per-host limit.
+type feedTask struct {
+	URL   string
+	Fetch func(ctx context.Context)
+}
+
+// feedPool runs feed fetches in parallel. The number of fetches in flight is
+// bounded both overall and per host, and the bounds are shared by every
+// caller, so a refresh and an OPML import running together stay within them.
+type feedPool struct {
+	slots   chan struct{}
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+var (
+	sharedFeedPool     *feedPool
+	sharedFeedPoolOnce sync.Once
+)
+
+// getFeedPool returns the pool shared by all feed fetches, sized by the
+// FEED_FETCH_WORKERS and FEED_FETCH_PER_HOST environment variables.
+func getFeedPool() *feedPool {
+	sharedFeedPoolOnce.Do(func() {
+		sharedFeedPool = newFeedPool(
+			getPositiveIntEnv("FEED_FETCH_WORKERS", defaultFeedFetchWorkers),
+			getPositiveIntEnv("FEED_FETCH_PER_HOST", defaultFeedFetchPerHost),
+		)
+	})
+	return sharedFeedPool
+}
+
+func newFeedPool(workers int, perHost int) *feedPool {
+	return &feedPool{
+		slots:   make(chan struct{}, workers),
+		perHost: perHost,
+		hosts:   make(map[string]chan struct{}),
+	}
+}
+
+// Run runs every task and waits for them to finish. Once ctx is cancelled no
+// new task is started, and the running ones see the cancellation through the
+// context they are given.
+func (p *feedPool) Run(ctx context.Context, tasks []feedTask) {
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task feedTask) {
+			defer wg.Done()
+			p.Do(ctx, task)
+		}(task)
+	}
+	wg.Wait()
+}
+
+// Do runs a single task once a slot is free, and tells whether it ran. It
+// does not when ctx is cancelled first.
+func (p *feedPool) Do(ctx context.Context, task feedTask) bool {
+	hostSlots := p.hostSlots(task.URL)
+	if !acquire(ctx, hostSlots) {
+		return false
+	}
+	defer release(hostSlots)
+	if !acquire(ctx, p.slots) {
+		return false
+	}
+	defer release(p.slots)
+
+	task.Fetch(ctx)
+	return true
+}
+
+func (p *feedPool) hostSlots(rawURL string) chan struct{} {
+	host := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		host = strings.ToLower(parsed.Hostname())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	slots, ok := p.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, p.perHost)
+		p.hosts[host] = slots
+	}
+	return slots
+}
+
+func acquire(ctx context.Context, slots chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case slots <- struct{}{}:
+		return true
+	}
+}
+
+func release(slots chan struct{}) {
+	<-slots
+}
+
+// feedFetchTimeout bounds a single feed request, including reading its body.
+// It is configured in seconds with FEED_FETCH_TIMEOUT.
+func feedFetchTimeout() time.Duration {
+	seconds := getPositiveIntEnv("FEED_FETCH_TIMEOUT", int(defaultFeedFetchTimeout/time.Second))
+	return time.Duration(seconds) * time.Second
+}
+
+func getPositiveIntEnv(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
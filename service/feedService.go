@@ -0,0 +1,211 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/akhilrex/podgrab/model"
+	pkgErrors "github.com/pkg/errors"
+)
+
+const (
+	feedFormatRss  = "rss"
+	feedFormatAtom = "atom"
+)
+
+// ParseFeed detects the format of the feed document and normalizes it
+// into a model.Feed.
+func ParseFeed(body []byte) (model.Feed, error) {
+	format, err := detectFeedFormat(body)
+	if err != nil {
+		return model.Feed{}, err
+	}
+
+	switch format {
+	case feedFormatAtom:
+		var data model.AtomFeed
+		if err := xml.Unmarshal(body, &data); err != nil {
+			return model.Feed{}, pkgErrors.Wrap(err, "failed to parse atom feed")
+		}
+		return atomToFeed(data), nil
+	default:
+		var data model.PodcastData
+		if err := xml.Unmarshal(body, &data); err != nil {
+			return model.Feed{}, pkgErrors.Wrap(err, "failed to parse rss feed")
+		}
+		return rssToFeed(data, body), nil
+	}
+}
+
+// detectFeedFormat looks at the root element of the document to decide
+// whether it is an RSS or an Atom feed.
+func detectFeedFormat(body []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return "", errors.New("empty feed document")
+		}
+		if err != nil {
+			return "", pkgErrors.Wrap(err, "failed to read feed document")
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			switch strings.ToLower(start.Name.Local) {
+			case "feed":
+				return feedFormatAtom, nil
+			case "rss":
+				return feedFormatRss, nil
+			default:
+				return "", errors.New("unsupported feed format: " + start.Name.Local)
+			}
+		}
+	}
+}
+
+func rssToFeed(data model.PodcastData, body []byte) model.Feed {
+	feed := model.Feed{
+		Title:   data.Channel.Title,
+		Summary: data.Channel.Summary,
+		Author:  data.Channel.Author,
+		Image:   data.Channel.Image.URL,
+
+		NewFeedURL: strings.TrimSpace(data.Channel.NewFeedUrl),
+		IsComplete: strings.EqualFold(strings.TrimSpace(data.Channel.Complete), "yes"),
+	}
+	if feed.Summary == "" {
+		feed.Summary = data.Channel.Description
+	}
+	if feed.Image == "" {
+		feed.Image = getItunesImageUrl(body)
+	}
+	for _, link := range data.Channel.Link {
+		setFeedLink(&feed, link.Rel, link.Href)
+	}
+
+	for _, obj := range data.Channel.Item {
+		item := model.FeedItem{
+			Title:       obj.Title,
+			Summary:     obj.Summary,
+			Description: obj.Description,
+			Content:     obj.Encoded,
+			EpisodeType: obj.EpisodeType,
+			Duration:    obj.Duration,
+			PubDate:     obj.PubDate,
+			GUID:        obj.Guid.Text,
+			Image:       obj.Image.Href,
+			Enclosure: model.FeedEnclosure{
+				URL:    obj.Enclosure.URL,
+				Length: obj.Enclosure.Length,
+				Type:   obj.Enclosure.Type,
+			},
+			AlternateEnclosures: obj.AlternateEnclosure,
+			Transcripts:         obj.Transcript,
+		}
+		if item.GUID == "" {
+			item.GUID = fallbackGUID(&item)
+		}
+		setFeedItemChapters(&item, obj.Chapters)
+		feed.Items = append(feed.Items, item)
+	}
+	return feed
+}
+
+func atomToFeed(data model.AtomFeed) model.Feed {
+	feed := model.Feed{
+		Title:   data.Title,
+		Summary: data.Subtitle,
+		Author:  data.Author.Name,
+		Image:   data.Image.Href,
+	}
+	if feed.Image == "" {
+		feed.Image = data.Logo
+	}
+	if feed.Image == "" {
+		feed.Image = data.Icon
+	}
+	for _, link := range data.Link {
+		setFeedLink(&feed, link.Rel, link.Href)
+	}
+
+	for _, entry := range data.Entry {
+		item := model.FeedItem{
+			Title:       entry.Title,
+			Summary:     entry.Summary.Text,
+			Description: entry.Content.Text,
+			Content:     entry.Content.Text,
+			Duration:    entry.Duration,
+			PubDate:     entry.Published,
+			GUID:        entry.ID,
+			Image:       entry.Image.Href,
+			Transcripts: entry.Transcript,
+		}
+		if item.PubDate == "" {
+			item.PubDate = entry.Updated
+		}
+		if item.Description == "" {
+			item.Description = entry.Group.Description
+		}
+		if item.Image == "" {
+			item.Image = entry.Group.Thumbnail.URL
+		}
+
+		for _, link := range entry.Link {
+			if link.Rel == "enclosure" {
+				item.Enclosure = model.FeedEnclosure{
+					URL:    link.Href,
+					Length: link.Length,
+					Type:   link.Type,
+				}
+				break
+			}
+		}
+		if item.GUID == "" {
+			item.GUID = fallbackGUID(&item)
+		}
+		setFeedItemChapters(&item, entry.Chapters)
+
+		feed.Items = append(feed.Items, item)
+	}
+	return feed
+}
+
+// setFeedItemChapters splits the chapters elements of an item into the
+// podcast:chapters url and the inline Podlove chapters.
+func setFeedItemChapters(item *model.FeedItem, chapters []model.FeedChapters) {
+	for _, element := range chapters {
+		if element.URL != "" && item.ChaptersURL == "" {
+			item.ChaptersURL = element.URL
+		}
+		item.Chapters = append(item.Chapters, element.Chapter...)
+	}
+}
+
+// setFeedLink records the WebSub links of a feed.
+func setFeedLink(feed *model.Feed, rel string, href string) {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return
+	}
+	switch strings.ToLower(rel) {
+	case "hub":
+		if feed.HubURL == "" {
+			feed.HubURL = href
+		}
+	case "self":
+		if feed.SelfURL == "" {
+			feed.SelfURL = href
+		}
+	case "next":
+		if feed.NextPageURL == "" {
+			feed.NextPageURL = href
+		}
+	case "prev-archive":
+		if feed.PrevArchiveURL == "" {
+			feed.PrevArchiveURL = href
+		}
+	}
+}
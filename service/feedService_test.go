@@ -0,0 +1,200 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/akhilrex/podgrab/model"
+)
+
+const testRssFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:podcast="https://podcastindex.org/namespace/1.0">
+  <channel>
+    <title>Test Show</title>
+    <description>The description of the show</description>
+    <itunes:summary>The summary of the show</itunes:summary>
+    <itunes:author>Test Author</itunes:author>
+    <itunes:new-feed-url> https://example.com/new.xml </itunes:new-feed-url>
+    <itunes:complete>Yes</itunes:complete>
+    <image><url>https://example.com/show.jpg</url></image>
+    <atom:link rel="hub" href="https://hub.example.com/"/>
+    <atom:link rel="self" href="https://example.com/feed.xml"/>
+    <atom:link rel="prev-archive" href="https://example.com/feed.xml?page=2"/>
+    <item>
+      <title>Episode 2</title>
+      <description>Notes of episode 2</description>
+      <content:encoded><![CDATA[<p>Notes of <b>episode 2</b></p>]]></content:encoded>
+      <itunes:summary>Summary of episode 2</itunes:summary>
+      <itunes:episodeType>full</itunes:episodeType>
+      <itunes:duration>1:02:03</itunes:duration>
+      <itunes:image href="https://example.com/2.jpg"/>
+      <pubDate>Tue, 02 Jan 2024 10:00:00 GMT</pubDate>
+      <guid isPermaLink="false">episode-2</guid>
+      <enclosure url="https://example.com/2.mp3" length="1234" type="audio/mpeg"/>
+      <podcast:transcript url="https://example.com/2.vtt" type="text/vtt" language="en"/>
+      <podcast:chapters url="https://example.com/2.json" type="application/json+chapters"/>
+    </item>
+    <item>
+      <title>Episode 1</title>
+      <pubDate>Mon, 01 Jan 2024 10:00:00 GMT</pubDate>
+      <enclosure url="https://dts.podtrac.com/redirect.mp3/Example.com/1.mp3?source=rss" length="1000" type="audio/mpeg"/>
+    </item>
+  </channel>
+</rss>`
+
+const testAtomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/">
+  <title>Atom Show</title>
+  <subtitle>The subtitle of the show</subtitle>
+  <id>urn:uuid:show</id>
+  <updated>2024-01-02T10:00:00Z</updated>
+  <icon>https://example.com/icon.png</icon>
+  <author><name>Atom Author</name></author>
+  <link rel="self" href="https://example.com/atom.xml"/>
+  <link rel="next" href="https://example.com/atom.xml?page=2"/>
+  <entry>
+    <title>Entry 2</title>
+    <id>urn:uuid:entry-2</id>
+    <published>2024-01-02T10:00:00Z</published>
+    <updated>2024-01-03T10:00:00Z</updated>
+    <summary>Summary of entry 2</summary>
+    <content type="html">Content of entry 2</content>
+    <link rel="alternate" href="https://example.com/2"/>
+    <link rel="enclosure" href="https://example.com/2.mp3" length="2000" type="audio/mpeg"/>
+    <link rel="enclosure" href="https://example.com/2.ogg" length="1500" type="audio/ogg"/>
+  </entry>
+  <entry>
+    <title>Entry 1</title>
+    <updated>2024-01-01T10:00:00Z</updated>
+    <media:group>
+      <media:thumbnail url="https://example.com/1.jpg"/>
+      <media:description>Description of entry 1</media:description>
+    </media:group>
+  </entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want model.Feed
+	}{
+		{
+			name: "rss",
+			body: testRssFeed,
+			want: model.Feed{
+				Title:          "Test Show",
+				Summary:        "The summary of the show",
+				Author:         "Test Author",
+				Image:          "https://example.com/show.jpg",
+				NewFeedURL:     "https://example.com/new.xml",
+				IsComplete:     true,
+				HubURL:         "https://hub.example.com/",
+				SelfURL:        "https://example.com/feed.xml",
+				PrevArchiveURL: "https://example.com/feed.xml?page=2",
+				Items: []model.FeedItem{
+					{
+						Title:       "Episode 2",
+						Summary:     "Summary of episode 2",
+						Description: "Notes of episode 2",
+						Content:     "<p>Notes of <b>episode 2</b></p>",
+						EpisodeType: "full",
+						Duration:    "1:02:03",
+						PubDate:     "Tue, 02 Jan 2024 10:00:00 GMT",
+						GUID:        "episode-2",
+						Image:       "https://example.com/2.jpg",
+						Enclosure:   model.FeedEnclosure{URL: "https://example.com/2.mp3", Length: "1234", Type: "audio/mpeg"},
+						Transcripts: []model.FeedTranscript{{URL: "https://example.com/2.vtt", Type: "text/vtt", Language: "en"}},
+						ChaptersURL: "https://example.com/2.json",
+					},
+					{
+						Title:     "Episode 1",
+						PubDate:   "Mon, 01 Jan 2024 10:00:00 GMT",
+						GUID:      "example.com/1.mp3",
+						Enclosure: model.FeedEnclosure{URL: "https://dts.podtrac.com/redirect.mp3/Example.com/1.mp3?source=rss", Length: "1000", Type: "audio/mpeg"},
+					},
+				},
+			},
+		},
+		{
+			name: "rss falling back to the description and itunes image",
+			body: `<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel>
+				<title>Show</title>
+				<description>Description</description>
+				<itunes:image href="https://example.com/itunes.jpg"/>
+			</channel></rss>`,
+			want: model.Feed{
+				Title:   "Show",
+				Summary: "Description",
+				Image:   "https://example.com/itunes.jpg",
+			},
+		},
+		{
+			name: "atom",
+			body: testAtomFeed,
+			want: model.Feed{
+				Title:       "Atom Show",
+				Summary:     "The subtitle of the show",
+				Author:      "Atom Author",
+				Image:       "https://example.com/icon.png",
+				SelfURL:     "https://example.com/atom.xml",
+				NextPageURL: "https://example.com/atom.xml?page=2",
+				Items: []model.FeedItem{
+					{
+						Title:       "Entry 2",
+						Summary:     "Summary of entry 2",
+						Description: "Content of entry 2",
+						Content:     "Content of entry 2",
+						PubDate:     "2024-01-02T10:00:00Z",
+						GUID:        "urn:uuid:entry-2",
+						Enclosure:   model.FeedEnclosure{URL: "https://example.com/2.mp3", Length: "2000", Type: "audio/mpeg"},
+					},
+					{
+						Title:       "Entry 1",
+						Description: "Description of entry 1",
+						PubDate:     "2024-01-01T10:00:00Z",
+						GUID:        "Entry 1|2024-01-01T10:00:00Z",
+						Image:       "https://example.com/1.jpg",
+					},
+				},
+			},
+		},
+		{
+			name: "atom preferring the logo to the icon",
+			body: `<feed xmlns="http://www.w3.org/2005/Atom">
+				<title>Show</title>
+				<logo>https://example.com/logo.png</logo>
+				<icon>https://example.com/icon.png</icon>
+			</feed>`,
+			want: model.Feed{
+				Title: "Show",
+				Image: "https://example.com/logo.png",
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseFeed([]byte(test.body))
+		if err != nil {
+			t.Errorf("%s: ParseFeed() error = %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ParseFeed() =\n%+v\nwant\n%+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseFeedRejectsInvalidDocuments(t *testing.T) {
+	tests := []string{
+		"",
+		"not a feed",
+		`<?xml version="1.0"?>`,
+		`<html><body>Not found</body></html>`,
+		`<rss version="2.0"><channel><title>Broken</channel></rss>`,
+	}
+	for _, body := range tests {
+		if feed, err := ParseFeed([]byte(body)); err == nil {
+			t.Errorf("ParseFeed(%q) = %+v, want an error", body, feed)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts tried, in order, once the leading weekday has been removed from a
+// date. RFC 822 style dates come first as they are what RSS mandates.
+var pubDateLayouts = []string{
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -07:00",
+	"2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04 MST",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04:05 MST",
+	"2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04:05 MST",
+	"2 January 2006 15:04 -0700",
+	"2 January 2006 15:04:05",
+	"2 Jan 2006",
+	"2 January 2006",
+	"Jan 2 2006 15:04:05 -0700",
+	"Jan 2 2006 15:04:05 MST",
+	"Jan 2 2006 15:04:05",
+	"Jan 2 2006",
+	"January 2 2006",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05.999999999-0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+}
+
+// Offsets of the zone abbreviations allowed by RFC 822, plus a few common
+// ones. Go only knows the abbreviations of the local zone, so they are
+// replaced by numeric offsets before parsing.
+var zoneOffsets = map[string]string{
+	"UT":   "+0000",
+	"UTC":  "+0000",
+	"GMT":  "+0000",
+	"Z":    "+0000",
+	"EST":  "-0500",
+	"EDT":  "-0400",
+	"CST":  "-0600",
+	"CDT":  "-0500",
+	"MST":  "-0700",
+	"MDT":  "-0600",
+	"PST":  "-0800",
+	"PDT":  "-0700",
+	"AEST": "+1000",
+	"AEDT": "+1100",
+	"BST":  "+0100",
+	"CET":  "+0100",
+	"CEST": "+0200",
+	"IST":  "+0530",
+}
+
+var (
+	parenthesised   = regexp.MustCompile(`\([^)]*\)`)
+	multipleSpaces  = regexp.MustCompile(`\s+`)
+	trailingZone    = regexp.MustCompile(`\s([A-Za-z]{1,4})$`)
+	gmtOffset       = regexp.MustCompile(`\s(?:GMT|UTC)([+-]\d{1,2})(?::?(\d{2}))?$`)
+	isoDuration     = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+	unitDuration    = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(hours|hour|hrs|hr|h|minutes|minute|mins|min|m|seconds|second|secs|sec|s)`)
+	unitDurations   = regexp.MustCompile(`^(?:[\s,]*\d+(?:\.\d+)?\s*(?:hours|hour|hrs|hr|h|minutes|minute|mins|min|m|seconds|second|secs|sec|s))+[\s,]*$`)
+	monthSeptPrefix = regexp.MustCompile(`\bSept\b`)
+)
+
+// ParsePubDate parses the publication date of a feed item. It understands
+// RFC 822/1123 dates and their many broken variants found in the wild, as
+// well as ISO 8601 timestamps.
+func ParsePubDate(raw string) (time.Time, bool) {
+	value := normalizePubDate(raw)
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	for _, layout := range pubDateLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func normalizePubDate(raw string) string {
+	value := parenthesised.ReplaceAllString(raw, "")
+	value = strings.ReplaceAll(value, ",", " ")
+	value = multipleSpaces.ReplaceAllString(strings.TrimSpace(value), " ")
+	if value == "" {
+		return ""
+	}
+
+	// Drop the weekday, it is redundant and frequently misspelled.
+	if first := strings.SplitN(value, " ", 2); len(first) == 2 && isWeekday(first[0]) {
+		value = first[1]
+	}
+
+	value = monthSeptPrefix.ReplaceAllString(value, "Sep")
+
+	if match := gmtOffset.FindStringSubmatch(value); match != nil {
+		hours, _ := strconv.Atoi(match[1])
+		minutes := match[2]
+		if minutes == "" {
+			minutes = "00"
+		}
+		sign := "+"
+		if hours < 0 {
+			sign = "-"
+			hours = -hours
+		}
+		offset := sign + leftPad(strconv.Itoa(hours)) + minutes
+		value = value[:len(value)-len(match[0])] + " " + offset
+	} else if match := trailingZone.FindStringSubmatch(value); match != nil {
+		if offset, ok := zoneOffsets[strings.ToUpper(match[1])]; ok {
+			value = value[:len(value)-len(match[1])] + offset
+		}
+	}
+	return value
+}
+
+func isWeekday(token string) bool {
+	token = strings.ToLower(strings.TrimSuffix(token, "."))
+	if len(token) < 2 {
+		return false
+	}
+	for _, day := range []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"} {
+		if strings.HasPrefix(day, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func leftPad(value string) string {
+	if len(value) < 2 {
+		return "0" + value
+	}
+	return value
+}
+
+// ParseDuration parses an itunes:duration into seconds. Plain seconds,
+// HH:MM:SS, MM:SS, ISO 8601 durations (PT1H2M3S) and values with units
+// (1h 2m, 45 min) are understood.
+func ParseDuration(raw string) (int, bool) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return int(seconds + 0.5), true
+	}
+
+	if strings.Contains(value, ":") {
+		return parseClockDuration(value)
+	}
+
+	upper := strings.ToUpper(value)
+	if match := isoDuration.FindStringSubmatch(upper); match != nil && upper != "P" && upper != "PT" {
+		var total float64
+		for i, multiplier := range []float64{86400, 3600, 60, 1} {
+			if match[i+1] == "" {
+				continue
+			}
+			part, _ := strconv.ParseFloat(match[i+1], 64)
+			total += part * multiplier
+		}
+		return int(total + 0.5), true
+	}
+
+	// Values with units must only be made of them, "P1H" is not an hour.
+	if lower := strings.ToLower(value); unitDurations.MatchString(lower) {
+		matches := unitDuration.FindAllStringSubmatch(lower, -1)
+		var total float64
+		for _, match := range matches {
+			part, _ := strconv.ParseFloat(match[1], 64)
+			switch match[2][0] {
+			case 'h':
+				total += part * 3600
+			case 'm':
+				total += part * 60
+			default:
+				total += part
+			}
+		}
+		return int(total + 0.5), true
+	}
+
+	return 0, false
+}
+
+// parseClockDuration parses HH:MM:SS and MM:SS durations. The last part may
+// have a fractional component.
+func parseClockDuration(value string) (int, bool) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, false
+	}
+	var total float64
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return 0, false
+		}
+		var number float64
+		var err error
+		if i == len(parts)-1 {
+			number, err = strconv.ParseFloat(part, 64)
+		} else {
+			var whole int
+			whole, err = strconv.Atoi(part)
+			number = float64(whole)
+		}
+		if err != nil || number < 0 {
+			return 0, false
+		}
+		total = total*60 + number
+	}
+	return int(total + 0.5), true
+}
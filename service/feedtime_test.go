@@ -0,0 +1,141 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePubDate(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want time.Time
+	}{
+		{"Mon, 02 Jan 2006 15:04:05 -0700", time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC)},
+		{"Mon, 02 Jan 2006 15:04:05 +01:00", time.Date(2006, 1, 2, 14, 4, 5, 0, time.UTC)},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"Mon, 02 Jan 2006 15:04:05 UT", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"Mon, 02 Jan 2006 15:04:05 EST", time.Date(2006, 1, 2, 20, 4, 5, 0, time.UTC)},
+		{"Mon, 02 Jan 2006 15:04:05 pdt", time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC)},
+		{"Mon, 02 Jan 2006 15:04:05 GMT+2", time.Date(2006, 1, 2, 13, 4, 5, 0, time.UTC)},
+		{"Mon, 02 Jan 2006 15:04:05 GMT-05:30", time.Date(2006, 1, 2, 20, 34, 5, 0, time.UTC)},
+		{"Mon, 02 Jan 2006 15:04:05 (UTC) +0000", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"Tues, 02 Jan 2006 15:04:05 +0000", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"Thurs., 5 Sept 2019 10:00:00 PDT", time.Date(2019, 9, 5, 17, 0, 0, 0, time.UTC)},
+		{"  Mon,   02  Jan 2006   15:04:05 +0000 ", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"02 Jan 2006 15:04 +0000", time.Date(2006, 1, 2, 15, 4, 0, 0, time.UTC)},
+		{"02 Jan 2006 15:04:05", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"02 Jan 06 15:04:05 +0000", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2 January 2006 15:04:05 +0000", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"02 Jan 2006", time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"January 2, 2006", time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"Jan 2, 2006 15:04:05 +0000", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006-01-02T15:04:05Z", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006-01-02T15:04:05.123Z", time.Date(2006, 1, 2, 15, 4, 5, 123000000, time.UTC)},
+		{"2006-01-02T15:04:05+02:00", time.Date(2006, 1, 2, 13, 4, 5, 0, time.UTC)},
+		{"2006-01-02T15:04:05+0200", time.Date(2006, 1, 2, 13, 4, 5, 0, time.UTC)},
+		{"2006-01-02T15:04Z", time.Date(2006, 1, 2, 15, 4, 0, 0, time.UTC)},
+		{"2006-01-02T15:04:05", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006-01-02 15:04:05 +0000", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006-01-02 15:04:05", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006-01-02", time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2006/01/02 15:04:05", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006/01/02", time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, ok := ParsePubDate(test.raw)
+		if !ok {
+			t.Errorf("ParsePubDate(%q) failed, want %v", test.raw, test.want)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("ParsePubDate(%q) = %v, want %v", test.raw, got.UTC(), test.want)
+		}
+	}
+}
+
+func TestParsePubDateRejectsInvalidDates(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"   ",
+		"(UTC)",
+		"not a date",
+		"Monday",
+		"32 Jan 2006 15:04:05 +0000",
+		"02 Foo 2006 15:04:05 +0000",
+		"2006-13-01",
+		"2006-01-02T25:00:00Z",
+		"1136214245",
+	} {
+		if got, ok := ParsePubDate(raw); ok {
+			t.Errorf("ParsePubDate(%q) = %v, want failure", raw, got)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want int
+	}{
+		{"0", 0},
+		{"3723", 3723},
+		{" 3723 ", 3723},
+		{"90.4", 90},
+		{"90.5", 91},
+		{"1:02:03", 3723},
+		{"01:02:03", 3723},
+		{"62:03", 3723},
+		{"0:45", 45},
+		{"1:02:03.6", 3724},
+		{"PT1H2M3S", 3723},
+		{"pt45m", 2700},
+		{"PT1.5M", 90},
+		{"P1DT1H", 90000},
+		{"PT30S", 30},
+		{"1h 2m 3s", 3723},
+		{"1h2m3s", 3723},
+		{"1h, 30m", 5400},
+		{"1 hour 2 minutes", 3720},
+		{"45 min", 2700},
+		{"45mins", 2700},
+		{"30 sec", 30},
+		{"1.5 hrs", 5400},
+	}
+	for _, test := range tests {
+		got, ok := ParseDuration(test.raw)
+		if !ok {
+			t.Errorf("ParseDuration(%q) failed, want %d", test.raw, test.want)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseDuration(%q) = %d, want %d", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestParseDurationRejectsInvalidDurations(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"   ",
+		"-5",
+		"-1.5",
+		"1:2:3:4",
+		"1:-2",
+		"-1:02",
+		"1::03",
+		":30",
+		"1:02:",
+		"1.5:30",
+		"a:b",
+		"P",
+		"PT",
+		"P1H",
+		"unknown",
+		"ten minutes",
+		"5 mins ago",
+	} {
+		if got, ok := ParseDuration(raw); ok {
+			t.Errorf("ParseDuration(%q) = %d, want failure", raw, got)
+		}
+	}
+}
@@ -14,10 +14,12 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/akhilrex/podgrab/db"
 	"github.com/akhilrex/podgrab/internal/sanitize"
+	"github.com/akhilrex/podgrab/model"
 	"github.com/gobeam/stringy"
 	pkgErrors "github.com/pkg/errors"
 )
@@ -27,68 +29,339 @@ const (
 	fileExtensionJpg = ".jpg"
 )
 
-func Download(link string, episodeTitle string, podcastName string, prefix string) (string, error) {
+// partFileExtension marks files which are still being downloaded. They are
+// renamed to their final name once complete, so that a file with the final
+// name is always a whole one.
+const partFileExtension = ".part"
+
+// validatorFileExtension is appended to the name of a part file for the file
+// keeping the ETag or Last-Modified of the response it was started from.
+const validatorFileExtension = ".validator"
+
+// Largest transcript saved, even the transcript of a long episode is a few
+// megabytes at most.
+const maxTranscriptSize = 20 << 20
+
+// Shortest interval between two progress reports of a download.
+const downloadProgressInterval = 500 * time.Millisecond
+
+// downloadProgress receives the bytes of a download saved so far and its
+// total size, 0 when unknown.
+type downloadProgress func(transferred int64, total int64)
+
+// progressWriter counts the bytes written through it and reports them at most
+// every downloadProgressInterval.
+type progressWriter struct {
+	written  int64
+	total    int64
+	report   downloadProgress
+	reported time.Time
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if time.Since(w.reported) >= downloadProgressInterval {
+		w.reported = time.Now()
+		w.report(w.written, w.total)
+	}
+	return len(p), nil
+}
+
+// Download saves the episode at link in the folder of the podcast and returns
+// its path. The file is written to a .part file first, which the next attempt
+// resumes with a Range request when the server supports it. It is only moved
+// into place when it is complete and looks like a media file of about the
+// enclosure length, which is ignored when 0. A file already at the final path
+// is checked the same way, by resuming it as a part file. progress, when not
+// nil, is told how far along the download is.
+func Download(link string, episodeTitle string, podcastName string, prefix string, enclosureType string, enclosureLength int64, credentials *model.FeedCredentials, progress downloadProgress) (string, error) {
 
 	if link == "" {
 		return "", errors.New("download path empty")
 	}
 
-	client := httpClient()
+	folder, err := createDataFolderIfNotExists(podcastName)
+	if err != nil {
+		return "", pkgErrors.Wrap(err, "failed to create data folder")
+	}
 
-	req, err := createGetRequest(link)
+	// The name of the part file cannot depend on the response, which is
+	// only known after deciding what range to ask for.
+	partName, err := downloadFileName(link, episodeTitle, prefix, "", enclosureType)
 	if err != nil {
-		return "", pkgErrors.Wrap(err, "failed to create request")
+		return "", pkgErrors.Wrap(err, "failed to get file name")
+	}
+	partPath := path.Join(folder, partName+partFileExtension)
+	if finalPath := path.Join(folder, partName); FileExists(finalPath) {
+		// It may be the leftover of an interrupted download. Resuming it
+		// asks the server for what is missing, nothing when it is whole.
+		err = removePartFile(partPath)
+		if err == nil {
+			err = os.Rename(finalPath, partPath)
+		}
+		if err != nil {
+			return "", pkgErrors.Wrap(err, "failed to check existing file")
+		}
 	}
 
-	resp, err := client.Do(req)
+	resp, offset, err := requestDownload(link, partPath, credentials)
 	if err != nil {
-		return "", pkgErrors.Wrap(err, "failed to get response")
+		return "", err
 	}
+	defer resp.Body.Close()
 
-	fileName, err := generateFileName(link, episodeTitle, fileExtensionMp3)
+	fileName, err := downloadFileName(link, episodeTitle, prefix, resp.Header.Get("Content-Type"), enclosureType)
 	if err != nil {
 		return "", pkgErrors.Wrap(err, "failed to get file name")
 	}
+	finalPath := path.Join(folder, fileName)
 
-	if prefix != "" {
-		fileName = fmt.Sprintf("%s-%s", prefix, fileName)
+	counter := &progressWriter{written: offset, total: enclosureLength, report: progress}
+	if resp.StatusCode == http.StatusPartialContent {
+		if _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && total > 0 {
+			counter.total = total
+		}
+	} else if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		counter.total = offset
+	} else if resp.ContentLength >= 0 {
+		counter.total = resp.ContentLength
 	}
 
-	folder, err := createDataFolderIfNotExists(podcastName)
+	if finalPath != path.Join(folder, partName) && FileExists(finalPath) {
+		if isCompleteDownload(finalPath, enclosureLength, counter.total) {
+			removePartFile(partPath)
+			return completeDownload(finalPath)
+		}
+		fmt.Println("Replacing incomplete file " + finalPath)
+		err = os.Remove(finalPath)
+		if err != nil {
+			return "", pkgErrors.Wrap(err, "failed to remove incomplete file")
+		}
+	}
+	if counter.report == nil {
+		counter.report = func(int64, int64) {}
+	}
+	counter.report(counter.written, counter.total)
+
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		if isWebPageContentType(resp.Header.Get("Content-Type")) {
+			return "", errors.New("server sent a web page instead of the episode")
+		}
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if offset > 0 {
+			flags = os.O_WRONLY | os.O_APPEND
+		}
+		file, err := os.OpenFile(partPath, flags, 0644)
+		if err != nil {
+			Logger.Errorw("Error creating file"+RedactURL(link), err)
+			return "", err
+		}
+		written, err := io.Copy(file, io.TeeReader(resp.Body, counter))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		counter.report(counter.written, counter.total)
+		if err != nil {
+			return "", pkgErrors.Wrap(redactError(err), "failed to save file")
+		}
+		if resp.ContentLength >= 0 && written != resp.ContentLength {
+			return "", fmt.Errorf("incomplete download, got %d of %d bytes", written, resp.ContentLength)
+		}
+	}
+
+	err = validateDownload(partPath, enclosureLength)
 	if err != nil {
-		return "", pkgErrors.Wrap(err, "failed to create data folder")
+		removePartFile(partPath)
+		return "", err
 	}
+	err = os.Rename(partPath, finalPath)
+	if err != nil {
+		return "", pkgErrors.Wrap(err, "failed to move downloaded file")
+	}
+	os.Remove(partPath + validatorFileExtension)
+	return completeDownload(finalPath)
 
-	finalPath := path.Join(folder, fileName)
+}
 
-	if _, err := os.Stat(finalPath); !os.IsNotExist(err) {
-		err = changeOwnership(finalPath)
+// requestDownload requests link, only asking for what is missing from the
+// part file when there is one. It returns the response along with the offset
+// to write it at, 0 when the server sends the whole file. A response with the
+// status 416 means the part file already holds the whole file.
+// The range is asked for with If-Range when the part file has a validator, so
+// that a file replaced at the same URL, as with dynamically inserted ads, is
+// sent whole rather than spliced onto the old one. Part files without one are
+// resumed with a plain Range request, and left to the validation of the
+// complete file.
+func requestDownload(link string, partPath string, credentials *model.FeedCredentials) (*http.Response, int64, error) {
+	client := httpClient(credentials)
+	var offset int64
+	validator := readPartValidator(partPath)
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	for {
+		req, err := createGetRequest(link, credentials)
 		if err != nil {
-			return "", pkgErrors.Wrap(err, "failed to change ownership")
+			return nil, 0, pkgErrors.Wrap(err, "failed to create request")
 		}
-		return finalPath, nil
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			if validator != "" {
+				req.Header.Set("If-Range", validator)
+			}
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, 0, pkgErrors.Wrap(redactError(err), "failed to get response")
+		}
+
+		switch {
+		case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+			start, _, ok := parseContentRange(resp.Header.Get("Content-Range"))
+			if ok && start == offset {
+				return resp, offset, nil
+			}
+		case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+			_, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+			if ok && total == offset {
+				return resp, offset, nil
+			}
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			savePartValidator(partPath, resp)
+			return resp, 0, nil
+		default:
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("failed to download, status %d", resp.StatusCode)
+		}
+
+		// The server cannot resume from the part file, start over.
+		resp.Body.Close()
+		fmt.Println("Restarting download of " + RedactURL(link))
+		err = removePartFile(partPath)
+		if err != nil {
+			return nil, 0, pkgErrors.Wrap(err, "failed to remove partial file")
+		}
+		offset = 0
 	}
+}
 
-	file, err := os.Create(finalPath)
+// responseValidator returns what identifies the version of the file sent in a
+// response for If-Range: its ETag when it is a strong one, which If-Range
+// requires, or else its Last-Modified date.
+func responseValidator(resp *http.Response) string {
+	if etag := strings.TrimSpace(resp.Header.Get("ETag")); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return strings.TrimSpace(resp.Header.Get("Last-Modified"))
+}
+
+func readPartValidator(partPath string) string {
+	validator, err := os.ReadFile(partPath + validatorFileExtension)
 	if err != nil {
-		Logger.Errorw("Error creating file"+link, err)
-		return "", err
+		return ""
 	}
-	defer resp.Body.Close()
+	return strings.TrimSpace(string(validator))
+}
 
-	_, err = io.Copy(file, resp.Body)
+// savePartValidator keeps the validator of the response a part file is
+// started from, or removes the one of a previous download when there is none.
+func savePartValidator(partPath string, resp *http.Response) {
+	validator := responseValidator(resp)
+	if validator == "" {
+		os.Remove(partPath + validatorFileExtension)
+		return
+	}
+	err := os.WriteFile(partPath+validatorFileExtension, []byte(validator), 0644)
 	if err != nil {
-		return "", pkgErrors.Wrap(err, "failed to save file")
+		Logger.Errorw("Error saving validator of "+partPath, err)
 	}
-	defer file.Close()
+}
 
-	err = changeOwnership(finalPath)
+// removePartFile removes a part file along with its validator.
+func removePartFile(partPath string) error {
+	os.Remove(partPath + validatorFileExtension)
+	err := os.Remove(partPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// parseContentRange reads the first byte and the total size from a
+// Content-Range header, the first byte being -1 for "bytes */total" and the
+// total -1 when unknown.
+func parseContentRange(contentRange string) (int64, int64, bool) {
+	unit, spec, ok := strings.Cut(strings.TrimSpace(contentRange), " ")
+	if !ok || unit != "bytes" {
+		return 0, 0, false
+	}
+	byteRange, totalSize, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, false
+	}
+	total := int64(-1)
+	if totalSize != "*" {
+		parsed, err := strconv.ParseInt(totalSize, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		total = parsed
+	}
+	if byteRange == "*" {
+		return -1, total, true
+	}
+	first, _, ok := strings.Cut(byteRange, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
 	if err != nil {
-		return "", pkgErrors.Wrap(err, "failed to change ownership")
+		return 0, 0, false
 	}
+	return start, total, true
+}
 
-	return finalPath, nil
+func validateDownload(filePath string, enclosureLength int64) error {
+	err := checkMediaFile(filePath)
+	if err != nil {
+		return pkgErrors.Wrap(err, "invalid download")
+	}
+	size, err := GetFileSize(filePath)
+	if err != nil {
+		return err
+	}
+	return pkgErrors.Wrap(checkDownloadLength(size, enclosureLength), "invalid download")
+}
 
+func downloadFileName(link string, episodeTitle string, prefix string, contentType string, enclosureType string) (string, error) {
+	fileName, err := generateMediaFileName(link, episodeTitle, contentType, enclosureType)
+	if err != nil {
+		return "", err
+	}
+	if prefix != "" {
+		fileName = fmt.Sprintf("%s-%s", prefix, fileName)
+	}
+	return fileName, nil
+}
+
+// isCompleteDownload tells whether the file at filePath is a valid download of
+// the size the server announces, when known.
+func isCompleteDownload(filePath string, enclosureLength int64, total int64) bool {
+	if validateDownload(filePath, enclosureLength) != nil {
+		return false
+	}
+	size, err := GetFileSize(filePath)
+	return err == nil && (total <= 0 || size == total)
+}
+
+func completeDownload(finalPath string) (string, error) {
+	err := changeOwnership(finalPath)
+	if err != nil {
+		return "", pkgErrors.Wrap(err, "failed to change ownership")
+	}
+	return finalPath, nil
 }
 
 func GetPodcastLocalImagePath(link string, podcastName string) (string, error) {
@@ -138,20 +411,20 @@ func CreateNfoFile(podcast *db.Podcast) error {
 	return os.WriteFile(finalPath, []byte(toPersist), 0644)
 }
 
-func DownloadPodcastCoverImage(link string, podcastName string) (string, error) {
+func DownloadPodcastCoverImage(link string, podcastName string, credentials *model.FeedCredentials) (string, error) {
 	if link == "" {
 		return "", errors.New("download path empty")
 	}
-	client := httpClient()
-	req, err := createGetRequest(link)
+	client := httpClient(credentials)
+	req, err := createGetRequest(link, credentials)
 	if err != nil {
-		Logger.Errorw("Error creating request: "+link, err)
+		Logger.Errorw("Error creating request: "+RedactURL(link), err)
 		return "", err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", pkgErrors.Wrap(err, "failed to get response: "+link)
+		return "", pkgErrors.Wrap(redactError(err), "failed to get response: "+RedactURL(link))
 	}
 	defer resp.Body.Close()
 
@@ -193,27 +466,27 @@ func DownloadPodcastCoverImage(link string, podcastName string) (string, error)
 	return finalPath, nil
 }
 
-func DownloadImage(link string, episodeId string, podcastName string) (string, error) {
+func DownloadImage(link string, episodeId string, podcastName string, credentials *model.FeedCredentials) (string, error) {
 	if link == "" {
 		return "", errors.New("download path empty")
 	}
-	client := httpClient()
-	req, err := createGetRequest(link)
+	client := httpClient(credentials)
+	req, err := createGetRequest(link, credentials)
 	if err != nil {
-		Logger.Errorw("Error creating request: "+link, err)
+		Logger.Errorw("Error creating request: "+RedactURL(link), err)
 		return "", err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		Logger.Errorw("Error getting response: "+link, err)
+		Logger.Errorw("Error getting response: "+RedactURL(link), redactError(err))
 		return "", err
 	}
 	defer resp.Body.Close()
 
 	fileName, err := generateFileName(link, episodeId, fileExtensionJpg)
 	if err != nil {
-		return "", pkgErrors.Wrap(err, "failed to get file name: "+link)
+		return "", pkgErrors.Wrap(err, "failed to get file name: "+RedactURL(link))
 	}
 
 	folder, err := createDataFolderIfNotExists(podcastName)
@@ -238,14 +511,14 @@ func DownloadImage(link string, episodeId string, podcastName string) (string, e
 
 	file, err := os.Create(finalPath)
 	if err != nil {
-		Logger.Errorw("Error creating file"+link, err)
+		Logger.Errorw("Error creating file"+RedactURL(link), err)
 		return "", err
 	}
 	defer file.Close()
 
 	_, err = io.Copy(file, resp.Body)
 	if err != nil {
-		Logger.Errorw("Error saving file"+link, err)
+		Logger.Errorw("Error saving file"+RedactURL(link), err)
 		return "", err
 	}
 
@@ -257,6 +530,55 @@ func DownloadImage(link string, episodeId string, podcastName string) (string, e
 	return finalPath, nil
 
 }
+
+// DownloadTranscript saves a transcript next to the audio file of its episode,
+// using the same base name with the given extension.
+func DownloadTranscript(link string, audioPath string, extension string, credentials *model.FeedCredentials) (string, error) {
+	if link == "" {
+		return "", errors.New("download path empty")
+	}
+	client := httpClient(credentials)
+	req, err := createGetRequest(link, credentials)
+	if err != nil {
+		return "", pkgErrors.Wrap(err, "failed to create request")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", pkgErrors.Wrap(redactError(err), "failed to get response: "+RedactURL(link))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d for transcript %s", resp.StatusCode, RedactURL(link))
+	}
+
+	finalPath := strings.TrimSuffix(audioPath, filepath.Ext(audioPath)) + extension
+
+	file, err := os.Create(finalPath)
+	if err != nil {
+		return "", pkgErrors.Wrap(err, "failed to create file")
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, io.LimitReader(resp.Body, maxTranscriptSize+1))
+	if err == nil && written > maxTranscriptSize {
+		err = fmt.Errorf("transcript larger than %d bytes", maxTranscriptSize)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(finalPath)
+		return "", pkgErrors.Wrap(err, "failed to save file")
+	}
+
+	err = changeOwnership(finalPath)
+	if err != nil {
+		return "", pkgErrors.Wrap(err, "failed to change ownership")
+	}
+
+	return finalPath, nil
+}
+
 func changeOwnership(path string) error {
 
 	uid, err1 := strconv.Atoi(os.Getenv("PUID"))
@@ -340,11 +662,18 @@ func deleteOldBackup() error {
 	return nil
 }
 
-func GetFileSizeFromUrl(url string) (int64, error) {
-	resp, err := http.Head(url)
+func GetFileSizeFromUrl(url string, credentials *model.FeedCredentials) (int64, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
 	if err != nil {
-		return 0, err
+		return 0, pkgErrors.Wrap(err, "failed to create request")
 	}
+	applyCredentials(req, credentials)
+
+	resp, err := httpClient(credentials).Do(req)
+	if err != nil {
+		return 0, redactError(err)
+	}
+	defer resp.Body.Close()
 
 	// Is our request ok?
 
@@ -434,10 +763,16 @@ func addFileToTarWriter(filePath string, tarWriter *tar.Writer) error {
 	return nil
 }
 
-func httpClient() *http.Client {
+// httpClient returns a client for requests made with the credentials, which
+// are not sent to the hosts it is redirected to.
+func httpClient(credentials *model.FeedCredentials) *http.Client {
 	client := http.Client{
 		CheckRedirect: func(r *http.Request, via []*http.Request) error {
 			//	r.URL.Opaque = r.URL.Path
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			dropCredentialsOnRedirect(r, via, credentials)
 			return nil
 		},
 	}
@@ -446,8 +781,9 @@ func httpClient() *http.Client {
 }
 
 // createGetRequest creates an HTTP GET request for the specified URL.
-// It also sets a custom User-Agent header if it is defined in the settings.
-func createGetRequest(url string) (*http.Request, error) {
+// It also sets a custom User-Agent header if it is defined in the settings,
+// and the credentials of the podcast the request is made for, if any.
+func createGetRequest(url string, credentials *model.FeedCredentials) (*http.Request, error) {
 
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
@@ -458,6 +794,7 @@ func createGetRequest(url string) (*http.Request, error) {
 	if len(setting.UserAgent) > 0 {
 		req.Header.Add("User-Agent", setting.UserAgent)
 	}
+	applyCredentials(req, credentials)
 
 	return req, nil
 }
@@ -530,6 +867,20 @@ func generateFileName(link string, title string, defaultExtension string) (strin
 	return str.KebabCase().Get() + ext, nil
 }
 
+// generateMediaFileName names an episode file. The extension of the URL is
+// kept when it is a known media one, otherwise it comes from the type sent by
+// the server or, failing that, the enclosure type in the feed.
+func generateMediaFileName(link string, title string, contentType string, enclosureType string) (string, error) {
+	if mediaTypeByExtension(link) == "" {
+		for _, mediaType := range []string{contentType, enclosureType} {
+			if ext := mediaExtension(mediaType); ext != "" {
+				return generateFileName("", title, ext)
+			}
+		}
+	}
+	return generateFileName(link, title, fileExtensionMp3)
+}
+
 func cleanFileName(original string) string {
 	return sanitize.Name(original)
 }
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		contentRange string
+		start        int64
+		total        int64
+		ok           bool
+	}{
+		{"bytes 100-199/200", 100, 200, true},
+		{"bytes 0-0/1", 0, 1, true},
+		{" bytes 100-199/* ", 100, -1, true},
+		{"bytes */200", -1, 200, true},
+		{"bytes */*", -1, -1, true},
+		{"", 0, 0, false},
+		{"bytes", 0, 0, false},
+		{"items 100-199/200", 0, 0, false},
+		{"bytes 100-199", 0, 0, false},
+		{"bytes 100/200", 0, 0, false},
+		{"bytes a-199/200", 0, 0, false},
+		{"bytes 100-199/b", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, total, ok := parseContentRange(test.contentRange)
+		if ok != test.ok {
+			t.Errorf("parseContentRange(%q) ok = %v, want %v", test.contentRange, ok, test.ok)
+			continue
+		}
+		if ok && (start != test.start || total != test.total) {
+			t.Errorf("parseContentRange(%q) = %d, %d, want %d, %d", test.contentRange, start, total, test.start, test.total)
+		}
+	}
+}
+
+func TestResponseValidator(t *testing.T) {
+	lastModified := "Wed, 21 Oct 2026 07:28:00 GMT"
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{"strong etag", http.Header{"Etag": {`"abc"`}, "Last-Modified": {lastModified}}, `"abc"`},
+		{"weak etag", http.Header{"Etag": {`W/"abc"`}, "Last-Modified": {lastModified}}, lastModified},
+		{"last modified", http.Header{"Last-Modified": {lastModified}}, lastModified},
+		{"none", http.Header{}, ""},
+	}
+	for _, test := range tests {
+		got := responseValidator(&http.Response{Header: test.header})
+		if got != test.want {
+			t.Errorf("%s: responseValidator() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPartValidator(t *testing.T) {
+	partPath := filepath.Join(t.TempDir(), "episode.mp3"+partFileExtension)
+	if err := os.WriteFile(partPath, []byte("part"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	savePartValidator(partPath, &http.Response{Header: http.Header{"Etag": {`"v1"`}}})
+	if got := readPartValidator(partPath); got != `"v1"` {
+		t.Fatalf("readPartValidator() = %q, want %q", got, `"v1"`)
+	}
+
+	// A response without a validator forgets the one of the previous one.
+	savePartValidator(partPath, &http.Response{Header: http.Header{}})
+	if got := readPartValidator(partPath); got != "" {
+		t.Fatalf("readPartValidator() = %q, want none", got)
+	}
+
+	savePartValidator(partPath, &http.Response{Header: http.Header{"Etag": {`"v2"`}}})
+	if err := removePartFile(partPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Errorf("part file still exists: %v", err)
+	}
+	if _, err := os.Stat(partPath + validatorFileExtension); !os.IsNotExist(err) {
+		t.Errorf("validator file still exists: %v", err)
+	}
+	if err := removePartFile(partPath); err != nil {
+		t.Errorf("removePartFile() of a missing file = %v", err)
+	}
+}
+
+// testEpisode is the content of the episode served to download tests.
+func testEpisode(version byte) []byte {
+	content := []byte("ID3")
+	for i := 0; len(content) < 4096; i++ {
+		content = append(content, byte(i)^version)
+	}
+	return content
+}
+
+// testEpisodePaths returns where Download saves the test episode and its part
+// file.
+func testEpisodePaths(t *testing.T, link string) (string, string) {
+	t.Helper()
+	folder, err := createDataFolderIfNotExists("Test Podcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName, err := downloadFileName(link, "Episode", "", "", "audio/mpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	finalPath := filepath.Join(folder, fileName)
+	return finalPath, finalPath + partFileExtension
+}
+
+func TestDownloadResume(t *testing.T) {
+	current := testEpisode(1)
+	tests := []struct {
+		name string
+		// ignoreRange makes the server send the whole file whatever is asked.
+		ignoreRange bool
+		// part, validator and existing are the files left by a previous
+		// attempt, at the part and final paths.
+		part      []byte
+		validator string
+		existing  []byte
+		// Range and If-Range headers of the request, and the status sent.
+		wantRange   string
+		wantIfRange string
+		wantStatus  int
+	}{
+		{
+			name:       "fresh download",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "resume",
+			part:        current[:1000],
+			validator:   `"v1"`,
+			wantRange:   "bytes=1000-",
+			wantIfRange: `"v1"`,
+			wantStatus:  http.StatusPartialContent,
+		},
+		{
+			name:       "resume without validator",
+			part:       current[:1000],
+			wantRange:  "bytes=1000-",
+			wantStatus: http.StatusPartialContent,
+		},
+		{
+			name:        "server ignoring the range",
+			ignoreRange: true,
+			part:        current[:1000],
+			validator:   `"v1"`,
+			wantRange:   "bytes=1000-",
+			wantIfRange: `"v1"`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "file changed since the part was saved",
+			part:        testEpisode(2)[:1000],
+			validator:   `"v0"`,
+			wantRange:   "bytes=1000-",
+			wantIfRange: `"v0"`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "part already complete",
+			part:        current,
+			validator:   `"v1"`,
+			wantRange:   fmt.Sprintf("bytes=%d-", len(current)),
+			wantIfRange: `"v1"`,
+			wantStatus:  http.StatusRequestedRangeNotSatisfiable,
+		},
+		{
+			name:       "truncated existing file",
+			existing:   current[:2000],
+			wantRange:  "bytes=2000-",
+			wantStatus: http.StatusPartialContent,
+		},
+		{
+			name:       "complete existing file",
+			existing:   current,
+			wantRange:  fmt.Sprintf("bytes=%d-", len(current)),
+			wantStatus: http.StatusRequestedRangeNotSatisfiable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTestDB(t)
+
+			var requests []http.Header
+			var statuses []int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Header.Clone())
+				w.Header().Set("ETag", `"v1"`)
+				if test.ignoreRange {
+					r.Header.Del("Range")
+				}
+				recorder := &statusRecorder{ResponseWriter: w}
+				http.ServeContent(recorder, r, "episode.mp3", time.Time{}, bytes.NewReader(current))
+				statuses = append(statuses, recorder.status)
+			}))
+			defer server.Close()
+
+			link := server.URL + "/episode.mp3"
+			finalPath, partPath := testEpisodePaths(t, link)
+			if test.part != nil {
+				if err := os.WriteFile(partPath, test.part, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.validator != "" {
+				if err := os.WriteFile(partPath+validatorFileExtension, []byte(test.validator), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.existing != nil {
+				if err := os.WriteFile(finalPath, test.existing, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var reported int64
+			got, err := Download(link, "Episode", "Test Podcast", "", "audio/mpeg", 0, nil, func(transferred int64, total int64) {
+				reported = transferred
+			})
+			if err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if got != finalPath {
+				t.Errorf("Download() = %s, want %s", got, finalPath)
+			}
+			if content, _ := os.ReadFile(finalPath); !bytes.Equal(content, current) {
+				t.Errorf("downloaded %d bytes which are not the episode", len(content))
+			}
+			if reported != int64(len(current)) {
+				t.Errorf("reported %d bytes transferred, want %d", reported, len(current))
+			}
+			for _, leftover := range []string{partPath, partPath + validatorFileExtension} {
+				if FileExists(leftover) {
+					t.Errorf("%s was not removed", filepath.Base(leftover))
+				}
+			}
+
+			if len(requests) != 1 {
+				t.Fatalf("%d requests, want 1", len(requests))
+			}
+			if got := requests[0].Get("Range"); got != test.wantRange {
+				t.Errorf("Range = %q, want %q", got, test.wantRange)
+			}
+			if got := requests[0].Get("If-Range"); got != test.wantIfRange {
+				t.Errorf("If-Range = %q, want %q", got, test.wantIfRange)
+			}
+			if statuses[0] != test.wantStatus {
+				t.Errorf("status %d, want %d", statuses[0], test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDownloadRejectsInvalidFiles(t *testing.T) {
+	setupTestDB(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("not an episode"))
+	}))
+	defer server.Close()
+
+	link := server.URL + "/episode.mp3"
+	finalPath, partPath := testEpisodePaths(t, link)
+	// An existing file which turns out not to be valid is not accepted.
+	if err := os.WriteFile(finalPath, []byte("not"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Download(link, "Episode", "Test Podcast", "", "audio/mpeg", 0, nil, nil); err == nil {
+		t.Error("Download() of an invalid file succeeded")
+	}
+	for _, leftover := range []string{finalPath, partPath} {
+		if FileExists(leftover) {
+			t.Errorf("%s was kept", filepath.Base(leftover))
+		}
+	}
+}
+
+// statusRecorder records the status written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
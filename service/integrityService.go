@@ -0,0 +1,183 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+	pkgErrors "github.com/pkg/errors"
+)
+
+// Files this much smaller than the length announced by the feed are taken
+// for failed downloads. The announced length is only indicative, dynamically
+// inserted ads make it vary, so smaller differences are accepted.
+const minEnclosureLengthRatio = 0.5
+
+// Signatures of the media containers found in podcasts, with their offset.
+var mediaSignatures = []struct {
+	Offset    int
+	Signature []byte
+}{
+	{0, []byte("ID3")},
+	{0, []byte("OggS")},
+	{0, []byte("fLaC")},
+	{0, []byte("RIFF")},
+	{0, []byte("FORM")},
+	{0, []byte("#!AMR")},
+	{0, []byte{0x1A, 0x45, 0xDF, 0xA3}},
+	{0, []byte{0x30, 0x26, 0xB2, 0x75}},
+	{4, []byte("ftyp")},
+}
+
+// parseEnclosureLength reads the length of an enclosure, 0 when it is
+// missing or invalid.
+func parseEnclosureLength(length string) int64 {
+	parsed, err := strconv.ParseInt(strings.TrimSpace(length), 10, 64)
+	if err != nil || parsed < 0 {
+		return 0
+	}
+	return parsed
+}
+
+// isWebPageContentType tells whether a response is a web page, as servers
+// send instead of the file when it is missing or access is denied.
+func isWebPageContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+// checkMediaFile tells why the file is not a media file, nil when it looks
+// like one. Files with the signature of a media container are accepted, as
+// well as unknown binary data since MPEG audio does not always start with
+// one. Files recognized as something else, like web pages, text or images,
+// are not.
+func checkMediaFile(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	head = head[:n]
+	if len(head) == 0 {
+		return errors.New("file is empty")
+	}
+
+	for _, known := range mediaSignatures {
+		if len(head) >= known.Offset+len(known.Signature) && bytes.Equal(head[known.Offset:known.Offset+len(known.Signature)], known.Signature) {
+			return nil
+		}
+	}
+	// MPEG audio and ADTS frames start with 11 set bits.
+	if len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0 {
+		return nil
+	}
+
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if strings.HasPrefix(detected, "audio/") || strings.HasPrefix(detected, "video/") || detected == "application/octet-stream" {
+		return nil
+	}
+	return fmt.Errorf("file is not a media file but %s", detected)
+}
+
+// checkDownloadLength compares the size of a downloaded file with the length
+// of the enclosure announced by the feed.
+func checkDownloadLength(size int64, enclosureLength int64) error {
+	if enclosureLength > 0 && float64(size) < float64(enclosureLength)*minEnclosureLengthRatio {
+		return fmt.Errorf("file has %d bytes while the feed announces %d", size, enclosureLength)
+	}
+	return nil
+}
+
+// downloadedEnclosureLength returns the length announced for the enclosure
+// the episode was downloaded from, which may be an alternate one. When several
+// alternates have its type the smallest length is used, and 0 when none does
+// anymore.
+func downloadedEnclosureLength(podcastItem *db.PodcastItem) int64 {
+	if podcastItem.DownloadMediaType == "" || podcastItem.DownloadMediaType == podcastItem.EnclosureType {
+		return podcastItem.EnclosureLength
+	}
+	length := int64(-1)
+	for _, alternate := range podcastItem.AlternateEnclosures {
+		if alternate.Type == podcastItem.DownloadMediaType && (length < 0 || alternate.Length < length) {
+			length = alternate.Length
+		}
+	}
+	if length < 0 {
+		return 0
+	}
+	return length
+}
+
+// CheckDownloadIntegrity checks the files downloaded since the last run. The
+// ones which are not media files, or much smaller than the feed announces,
+// are flagged and, when they can be downloaded again, deleted and queued for
+// download.
+func CheckDownloadIntegrity() error {
+	const JOB_NAME = "CheckDownloadIntegrity"
+	lock := db.GetLock(JOB_NAME)
+	if lock.IsLocked() {
+		fmt.Println(JOB_NAME + " is locked")
+		return nil
+	}
+	db.Lock(JOB_NAME, 60)
+	defer db.Unlock(JOB_NAME)
+
+	items, err := db.GetPodcastItemsToCheckIntegrity()
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to get podcast items to check")
+	}
+	for _, item := range *items {
+		if !FileExists(item.DownloadPath) {
+			continue
+		}
+		now := time.Now()
+		checkErr := checkMediaFile(item.DownloadPath)
+		if checkErr == nil {
+			size, err := GetFileSize(item.DownloadPath)
+			if err != nil {
+				Logger.Errorw("Error getting the size of "+item.Title, err)
+				continue
+			}
+			checkErr = checkDownloadLength(size, downloadedEnclosureLength(&item))
+		}
+		if checkErr == nil {
+			err := db.UpdatePodcastItemIntegrity(item.ID, "", now)
+			if err != nil {
+				return pkgErrors.Wrap(err, "failed to update integrity")
+			}
+			continue
+		}
+
+		fmt.Println("Invalid file for " + item.Title + ": " + checkErr.Error())
+		if item.FileURL != "" && item.Podcast.LocalFolder == "" {
+			err := DeleteFile(item.DownloadPath)
+			if err != nil && !os.IsNotExist(err) {
+				Logger.Errorw("Error deleting invalid file of "+item.Title, err)
+				continue
+			}
+			err = SetPodcastItemAsNotDownloaded(item.ID, db.NotDownloaded)
+			if err != nil {
+				return pkgErrors.Wrap(err, "failed to queue podcast item")
+			}
+		}
+		err := db.UpdatePodcastItemIntegrity(item.ID, checkErr.Error(), now)
+		if err != nil {
+			return pkgErrors.Wrap(err, "failed to update integrity")
+		}
+	}
+	return nil
+}
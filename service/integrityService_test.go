@@ -0,0 +1,115 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+)
+
+func TestCheckDownloadIntegrity(t *testing.T) {
+	setupTestDB(t)
+	podcast := db.Podcast{Title: "Show", URL: "https://example.com/feed.xml"}
+	if err := db.CreatePodcast(&podcast); err != nil {
+		t.Fatal(err)
+	}
+
+	episode := testEpisode(1)
+	tests := []struct {
+		name    string
+		item    db.PodcastItem
+		content []byte
+		valid   bool
+	}{
+		{
+			name:    "complete",
+			item:    db.PodcastItem{EnclosureType: "audio/mpeg", EnclosureLength: int64(len(episode))},
+			content: episode,
+			valid:   true,
+		},
+		{
+			name:    "length not announced",
+			item:    db.PodcastItem{EnclosureType: "audio/mpeg"},
+			content: episode[:100],
+			valid:   true,
+		},
+		{
+			name:    "truncated",
+			item:    db.PodcastItem{EnclosureType: "audio/mpeg", EnclosureLength: int64(len(episode))},
+			content: episode[:1000],
+		},
+		{
+			name:    "not a media file",
+			item:    db.PodcastItem{EnclosureType: "audio/mpeg"},
+			content: []byte("<html><body>Not found</body></html>"),
+		},
+		{
+			name: "alternate enclosure",
+			item: db.PodcastItem{
+				EnclosureType:     "audio/mpeg",
+				EnclosureLength:   int64(len(episode)) * 10,
+				DownloadMediaType: "audio/aac",
+				AlternateEnclosures: []db.AlternateEnclosure{
+					{Type: "audio/aac", Length: int64(len(episode)) * 2},
+					{Type: "audio/aac", Length: int64(len(episode))},
+					{Type: "audio/opus", Length: int64(len(episode)) * 10},
+				},
+			},
+			content: episode,
+			valid:   true,
+		},
+		{
+			name: "truncated alternate enclosure",
+			item: db.PodcastItem{
+				EnclosureType:       "audio/mpeg",
+				EnclosureLength:     int64(len(episode)),
+				DownloadMediaType:   "audio/aac",
+				AlternateEnclosures: []db.AlternateEnclosure{{Type: "audio/aac", Length: int64(len(episode)) * 10}},
+			},
+			content: episode,
+		},
+	}
+
+	folder := t.TempDir()
+	for i := range tests {
+		item := &tests[i].item
+		item.PodcastID = podcast.ID
+		item.Title = tests[i].name
+		item.FileURL = "https://example.com/episode.mp3"
+		item.DownloadStatus = db.Downloaded
+		item.DownloadDate = time.Now()
+		item.DownloadPath = filepath.Join(folder, tests[i].name+".mp3")
+		if err := os.WriteFile(item.DownloadPath, tests[i].content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreatePodcastItem(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := CheckDownloadIntegrity(); err != nil {
+		t.Fatalf("CheckDownloadIntegrity() error = %v", err)
+	}
+
+	for _, test := range tests {
+		var item db.PodcastItem
+		if err := db.GetPodcastItemById(test.item.ID, &item); err != nil {
+			t.Fatal(err)
+		}
+		if item.IntegrityCheckDate == nil {
+			t.Errorf("%s: not checked", test.name)
+		}
+		if test.valid {
+			if item.IntegrityError != "" || item.DownloadStatus != db.Downloaded || !FileExists(test.item.DownloadPath) {
+				t.Errorf("%s: flagged %q with status %v, want a valid download", test.name, item.IntegrityError, item.DownloadStatus)
+			}
+			continue
+		}
+		// Invalid files are deleted and downloaded again.
+		if item.IntegrityError == "" || item.DownloadStatus != db.NotDownloaded || FileExists(test.item.DownloadPath) {
+			t.Errorf("%s: flagged %q with status %v, want an invalid download queued again", test.name, item.IntegrityError, item.DownloadStatus)
+		}
+	}
+}
@@ -22,7 +22,7 @@ const ItunesBase = "https://itunes.apple.com"
 func (service ItunesService) Query(q string) ([]*model.CommonSearchResultModel, error) {
 	u := fmt.Sprintf("%s/search?term=%s&entity=podcast", ItunesBase, url.QueryEscape(q))
 
-	body, _ := makeQuery(u)
+	body, _ := makeQuery(u, nil)
 	var response model.ItunesResponse
 
 	err := json.Unmarshal(body, &response)
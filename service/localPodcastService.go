@@ -0,0 +1,312 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/internal/id3"
+	"github.com/akhilrex/podgrab/model"
+	pkgErrors "github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Prefix of the GUIDs of local episodes, followed by the path of their file
+// within the folder of the podcast.
+const localGUIDPrefix = "local:"
+
+// Names of the cover image looked for in the folder of a local podcast.
+var localCoverNames = []string{"cover.jpg", "cover.jpeg", "cover.png", "folder.jpg", "folder.jpeg", "folder.png", "front.jpg", "front.png"}
+
+var (
+	fileNameDate        = regexp.MustCompile(`^(\d{4})[-_.](\d{2})[-_.](\d{2})[\s_.-]*`)
+	fileNameSeparators  = regexp.MustCompile(`[_\s]+`)
+	errLocalPodcastFile = errors.New("files of local podcasts are managed on disk")
+)
+
+// Local podcasts being scanned, so that the job and the scan of a podcast
+// which was just added never add the same files twice.
+var localScansRunning sync.Map
+
+// localFolderPath checks that folder, absolute or relative to the data
+// folder, is a directory within the data folder. It returns its absolute path
+// and its path relative to the data folder.
+func localFolderPath(folder string) (string, string, error) {
+	dataPath, err := filepath.Abs(os.Getenv("DATA"))
+	if err != nil {
+		return "", "", pkgErrors.Wrap(err, "failed to get data folder")
+	}
+	folder = strings.TrimSpace(folder)
+	if folder == "" {
+		return "", "", errors.New("folder is required")
+	}
+	if !filepath.IsAbs(folder) {
+		folder = filepath.Join(dataPath, folder)
+	}
+	folder = filepath.Clean(folder)
+	relative, err := filepath.Rel(dataPath, folder)
+	if err != nil || relative == "." || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("folder must be within the data folder %s", dataPath)
+	}
+	info, err := os.Stat(folder)
+	if err != nil {
+		return "", "", pkgErrors.Wrap(err, "failed to read folder")
+	}
+	if !info.IsDir() {
+		return "", "", fmt.Errorf("%s is not a folder", folder)
+	}
+	return folder, relative, nil
+}
+
+// AddLocalPodcast creates a podcast from the audio files of a folder within
+// the data folder. The title defaults to the name of the folder.
+func AddLocalPodcast(folder string, title string, summary string) (db.Podcast, error) {
+	_, relative, err := localFolderPath(folder)
+	if err != nil {
+		return db.Podcast{}, err
+	}
+
+	var podcast db.Podcast
+	err = db.GetPodcastByLocalFolder(relative, &podcast)
+	if err == nil {
+		return podcast, &model.PodcastAlreadyExistsError{Url: relative}
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return db.Podcast{}, err
+	}
+
+	title = strings.TrimSpace(title)
+	if title == "" {
+		title = filepath.Base(relative)
+	}
+	podcast = db.Podcast{
+		Title:       title,
+		Summary:     strings.TrimSpace(summary),
+		LocalFolder: relative,
+	}
+	err = db.CreatePodcast(&podcast)
+	if err != nil {
+		return db.Podcast{}, pkgErrors.Wrap(err, "failed to create podcast")
+	}
+	go func() {
+		err := scanLocalPodcast(&podcast)
+		if err != nil {
+			Logger.Errorw("Error scanning local podcast "+podcast.Title, err)
+		}
+	}()
+	return podcast, nil
+}
+
+// ScanLocalPodcasts adds the new audio files of the folders of local
+// podcasts as downloaded episodes, and removes the episodes whose file is
+// gone.
+func ScanLocalPodcasts() error {
+	const JOB_NAME = "ScanLocalPodcasts"
+	lock := db.GetLock(JOB_NAME)
+	if lock.IsLocked() {
+		fmt.Println(JOB_NAME + " is locked")
+		return nil
+	}
+	db.Lock(JOB_NAME, 60)
+	defer db.Unlock(JOB_NAME)
+
+	podcasts, err := db.GetLocalPodcasts()
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to get local podcasts")
+	}
+	for _, podcast := range *podcasts {
+		if podcast.IsPaused {
+			continue
+		}
+		err := scanLocalPodcast(&podcast)
+		if err != nil {
+			Logger.Errorw("Error scanning local podcast "+podcast.Title, err)
+		}
+	}
+	return nil
+}
+
+func scanLocalPodcast(podcast *db.Podcast) error {
+	if _, running := localScansRunning.LoadOrStore(podcast.ID, true); running {
+		return nil
+	}
+	defer localScansRunning.Delete(podcast.ID)
+
+	root, _, err := localFolderPath(podcast.LocalFolder)
+	if err != nil {
+		return err
+	}
+
+	var podcastItems []db.PodcastItem
+	err = db.GetAllPodcastItemsByPodcastId(podcast.ID, &podcastItems)
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to get podcast items")
+	}
+	existing := make(map[string]db.PodcastItem)
+	for _, item := range podcastItems {
+		existing[item.GUID] = item
+	}
+
+	seen := make(map[string]bool)
+	var latestDate time.Time
+	added := 0
+	err = filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && filePath != root {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || !strings.HasPrefix(mediaTypeByExtension(entry.Name()), "audio/") {
+			return nil
+		}
+		relative, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		guid := localGUIDPrefix + filepath.ToSlash(relative)
+		seen[guid] = true
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if item, ok := existing[guid]; ok {
+			if item.FileSize != info.Size() {
+				return db.UpdatePodcastItemFileSize(item.ID, info.Size())
+			}
+			return nil
+		}
+
+		podcastItem := newLocalPodcastItem(podcast, filePath, guid, info)
+		err = db.CreatePodcastItem(&podcastItem)
+		if err != nil {
+			return pkgErrors.Wrap(err, "failed to create podcast item")
+		}
+		readChaptersFromFile(podcastItem.ID)
+		if latestDate.Before(podcastItem.PubDate) {
+			latestDate = podcastItem.PubDate
+		}
+		added++
+		return nil
+	})
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to scan folder")
+	}
+
+	// A folder which looks empty is more likely an unmounted share or one
+	// which cannot be read than one whose files were all deleted. Episodes,
+	// with their played state and bookmarks, are only removed when some
+	// files are left.
+	if len(seen) == 0 && len(existing) > 0 {
+		return fmt.Errorf("no audio files found in %s, keeping its %d episodes", root, len(existing))
+	}
+	for guid, item := range existing {
+		if seen[guid] {
+			continue
+		}
+		err := db.DeletePodcastItemById(item.ID)
+		if err != nil {
+			return pkgErrors.Wrap(err, "failed to delete podcast item")
+		}
+		fmt.Println("Removed local episode whose file is gone: " + item.Title)
+	}
+
+	if added > 0 {
+		fmt.Printf("Added %d local episodes to %s\n", added, podcast.Title)
+	}
+	if podcast.LastEpisode == nil || latestDate.After(*podcast.LastEpisode) {
+		if (latestDate != time.Time{}) {
+			return db.UpdateLastEpisodeDateForPodcast(podcast.ID, latestDate)
+		}
+	}
+	return nil
+}
+
+// newLocalPodcastItem makes a downloaded episode of an audio file, titled and
+// described by its ID3 tag or else by its name. The publication date comes
+// from a date the name starts with, the recording date of the tag or the
+// modification time of the file.
+func newLocalPodcastItem(podcast *db.Podcast, filePath string, guid string, info fs.FileInfo) db.PodcastItem {
+	name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+	var pubDate time.Time
+	if match := fileNameDate.FindStringSubmatch(name); match != nil {
+		if date, err := time.ParseInLocation("2006-01-02", match[1]+"-"+match[2]+"-"+match[3], time.Local); err == nil {
+			pubDate = date
+			name = name[len(match[0]):]
+		}
+	}
+	title := strings.Trim(fileNameSeparators.ReplaceAllString(name, " "), " -.")
+	if title == "" {
+		title = info.Name()
+	}
+
+	var summary string
+	var duration int
+	if tag, err := id3.ReadFile(filePath); err == nil {
+		if tag.Title != "" {
+			title = tag.Title
+		}
+		summary = tag.Comment
+		duration = int(tag.Length.Seconds())
+		if (pubDate == time.Time{}) {
+			for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+				if date, err := time.ParseInLocation(layout, tag.Year, time.Local); err == nil {
+					pubDate = date
+					break
+				}
+			}
+		}
+	}
+	if (pubDate == time.Time{}) {
+		pubDate = info.ModTime()
+	}
+
+	return db.PodcastItem{
+		PodcastID:      podcast.ID,
+		Title:          title,
+		Summary:        summary,
+		ShowNotes:      html.EscapeString(summary),
+		EpisodeType:    "full",
+		Duration:       duration,
+		PubDate:        pubDate,
+		GUID:           guid,
+		EnclosureType:  mediaTypeByExtension(info.Name()),
+		DownloadDate:   info.ModTime(),
+		DownloadPath:   filePath,
+		DownloadStatus: db.Downloaded,
+		FileSize:       info.Size(),
+	}
+}
+
+// GetLocalPodcastImagePath returns the cover image found in the folder of a
+// local podcast, empty when there is none.
+func GetLocalPodcastImagePath(podcast *db.Podcast) string {
+	root, _, err := localFolderPath(podcast.LocalFolder)
+	if err != nil {
+		return ""
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return ""
+	}
+	for _, coverName := range localCoverNames {
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.EqualFold(entry.Name(), coverName) {
+				return filepath.Join(root, entry.Name())
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/akhilrex/podgrab/db"
+)
+
+func TestScanLocalPodcast(t *testing.T) {
+	setupTestDB(t)
+
+	folder := filepath.Join(os.Getenv("DATA"), "Local")
+	if err := os.Mkdir(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(folder, name), testEpisode(1), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remove := func(name string) {
+		t.Helper()
+		if err := os.Remove(filepath.Join(folder, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	podcast := db.Podcast{Title: "Local", LocalFolder: "Local"}
+	if err := db.CreatePodcast(&podcast); err != nil {
+		t.Fatal(err)
+	}
+	guids := func() []string {
+		t.Helper()
+		var items []db.PodcastItem
+		if err := db.GetAllPodcastItemsByPodcastId(podcast.ID, &items); err != nil {
+			t.Fatal(err)
+		}
+		guids := []string{}
+		for _, item := range items {
+			guids = append(guids, item.GUID)
+		}
+		sort.Strings(guids)
+		return guids
+	}
+
+	write("2024-01-01 First.mp3")
+	write("2024-01-02 Second.mp3")
+	write("notes.txt")
+	if err := scanLocalPodcast(&podcast); err != nil {
+		t.Fatal(err)
+	}
+	if got := guids(); len(got) != 2 || got[0] != "local:2024-01-01 First.mp3" || got[1] != "local:2024-01-02 Second.mp3" {
+		t.Fatalf("episodes %v, want the two audio files", got)
+	}
+
+	remove("2024-01-01 First.mp3")
+	if err := scanLocalPodcast(&podcast); err != nil {
+		t.Fatal(err)
+	}
+	if got := guids(); len(got) != 1 || got[0] != "local:2024-01-02 Second.mp3" {
+		t.Errorf("episodes %v, want the removed file to be gone", got)
+	}
+
+	// A folder which looks empty keeps its episodes.
+	remove("2024-01-02 Second.mp3")
+	if err := scanLocalPodcast(&podcast); err == nil {
+		t.Error("scanLocalPodcast() of an empty folder succeeded")
+	}
+	if got := guids(); len(got) != 1 {
+		t.Errorf("episodes %v after scanning an empty folder, want them kept", got)
+	}
+}
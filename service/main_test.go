@@ -0,0 +1,34 @@
+package service
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/akhilrex/podgrab/db"
+)
+
+// setupTestDB points the application at a new database and data folder in
+// temporary directories, for tests of code which reads the settings or saves
+// files.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG", t.TempDir())
+	t.Setenv("DATA", t.TempDir())
+	t.Setenv("PUID", strconv.Itoa(os.Getuid()))
+	t.Setenv("PGID", strconv.Itoa(os.Getgid()))
+
+	database, err := db.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := database.DB(); err == nil {
+			sqlDB.Close()
+		}
+		db.DB = nil
+	})
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/akhilrex/podgrab/db"
+)
+
+const defaultMediaType = "audio/mpeg"
+
+// Media types of the enclosures found in podcasts, with the extension files
+// of that type are saved with. The first extension of a type is preferred.
+var mediaExtensions = []struct {
+	Type      string
+	Extension string
+}{
+	{"audio/mpeg", ".mp3"},
+	{"audio/mp3", ".mp3"},
+	{"audio/mp4", ".m4a"},
+	{"audio/x-m4a", ".m4a"},
+	{"audio/m4a", ".m4a"},
+	{"audio/x-m4b", ".m4b"},
+	{"audio/aac", ".aac"},
+	{"audio/ogg", ".ogg"},
+	{"audio/opus", ".opus"},
+	{"audio/flac", ".flac"},
+	{"audio/x-flac", ".flac"},
+	{"audio/wav", ".wav"},
+	{"audio/x-wav", ".wav"},
+	{"audio/webm", ".weba"},
+	{"video/mp4", ".mp4"},
+	{"video/x-m4v", ".m4v"},
+	{"video/quicktime", ".mov"},
+	{"video/webm", ".webm"},
+	{"video/x-matroska", ".mkv"},
+	{"video/ogg", ".ogv"},
+	{"video/mpeg", ".mpeg"},
+}
+
+// mediaExtension returns the file extension for a media type, ignoring its
+// parameters. It is empty for unknown and generic types.
+func mediaExtension(mediaType string) string {
+	parsed, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return ""
+	}
+	for _, known := range mediaExtensions {
+		if known.Type == parsed {
+			return known.Extension
+		}
+	}
+	return ""
+}
+
+// mediaTypeByExtension returns the media type of a file name or URL from its
+// extension.
+func mediaTypeByExtension(name string) string {
+	if parsed, err := url.Parse(name); err == nil {
+		name = parsed.Path
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	for _, known := range mediaExtensions {
+		if known.Extension == ext {
+			return known.Type
+		}
+	}
+	return ""
+}
+
+// GetMediaType returns the media type of an episode: the type of the
+// enclosure which was downloaded, the enclosure type from the feed, or else
+// the one matching the extension of its file.
+func GetMediaType(podcastItem *db.PodcastItem) string {
+	if podcastItem.DownloadPath != "" {
+		if _, _, err := mime.ParseMediaType(podcastItem.DownloadMediaType); err == nil {
+			return podcastItem.DownloadMediaType
+		}
+	}
+	if _, _, err := mime.ParseMediaType(podcastItem.EnclosureType); err == nil {
+		return podcastItem.EnclosureType
+	}
+	if mediaType := mediaTypeByExtension(podcastItem.DownloadPath); mediaType != "" {
+		return mediaType
+	}
+	if mediaType := mediaTypeByExtension(podcastItem.FileURL); mediaType != "" {
+		return mediaType
+	}
+	return defaultMediaType
+}
+
+// IsVideo tells whether an episode is a video.
+func IsVideo(podcastItem *db.PodcastItem) bool {
+	return strings.HasPrefix(GetMediaType(podcastItem), "video/")
+}
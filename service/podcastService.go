@@ -1,19 +1,23 @@
 package service
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/TheHippo/podcastindex"
 	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/internal/sanitize"
 	"github.com/akhilrex/podgrab/model"
 	"github.com/antchfx/xmlquery"
 	strip "github.com/grokify/html-strip-tags-go"
@@ -36,14 +40,13 @@ func ParseOpml(content string) (model.OpmlModel, error) {
 	return response, err
 }
 
-// FetchURL is
-func FetchURL(url string) (model.PodcastData, []byte, error) {
-	body, err := makeQuery(url)
+// FetchURL fetches the feed at url and normalizes it, whatever its format.
+func FetchURL(url string) (model.Feed, []byte, error) {
+	body, err := makeQuery(url, nil)
 	if err != nil {
-		return model.PodcastData{}, nil, err
+		return model.Feed{}, nil, err
 	}
-	var response model.PodcastData
-	err = xml.Unmarshal(body, &response)
+	response, err := ParseFeed(body)
 	return response, body, err
 }
 func GetPodcastById(id string) (*db.Podcast, error) {
@@ -109,11 +112,11 @@ func GetAllPodcasts(sorting string) (*[]db.Podcast, error) {
 	for _, podcast := range podcasts {
 		podcast.DownloadedEpisodesCount = countMap[Key{podcast.ID, db.Downloaded}]
 		podcast.DownloadingEpisodesCount = countMap[Key{podcast.ID, db.NotDownloaded}]
-		podcast.AllEpisodesCount = podcast.DownloadedEpisodesCount + podcast.DownloadingEpisodesCount + countMap[Key{podcast.ID, db.Deleted}]
+		podcast.AllEpisodesCount = podcast.DownloadedEpisodesCount + podcast.DownloadingEpisodesCount + countMap[Key{podcast.ID, db.Deleted}] + countMap[Key{podcast.ID, db.Failed}]
 
 		podcast.DownloadedEpisodesSize = sizeMap[Key{podcast.ID, db.Downloaded}]
 		podcast.DownloadingEpisodesSize = sizeMap[Key{podcast.ID, db.NotDownloaded}]
-		podcast.AllEpisodesSize = podcast.DownloadedEpisodesSize + podcast.DownloadingEpisodesSize + sizeMap[Key{podcast.ID, db.Deleted}]
+		podcast.AllEpisodesSize = podcast.DownloadedEpisodesSize + podcast.DownloadingEpisodesSize + sizeMap[Key{podcast.ID, db.Deleted}] + sizeMap[Key{podcast.ID, db.Failed}]
 
 		toReturn = append(toReturn, podcast)
 	}
@@ -126,28 +129,27 @@ func AddOpml(content string) error {
 		fmt.Println(err.Error())
 		return errors.New("invalid file format")
 	}
-	var wg sync.WaitGroup
+	var urls []string
 	for _, outline := range opmlModel.Body.Outline {
 		if outline.XmlUrl != "" {
-			wg.Add(1)
-			go func(url string) {
-				defer wg.Done()
-				AddPodcast(url)
-
-			}(outline.XmlUrl)
+			urls = append(urls, outline.XmlUrl)
 		}
 
 		for _, innerOutline := range outline.Outline {
 			if innerOutline.XmlUrl != "" {
-				wg.Add(1)
-				go func(url string) {
-					defer wg.Done()
-					AddPodcast(url)
-				}(innerOutline.XmlUrl)
+				urls = append(urls, innerOutline.XmlUrl)
 			}
 		}
 	}
-	wg.Wait()
+
+	var tasks []feedTask
+	for _, url := range urls {
+		url := url
+		tasks = append(tasks, feedTask{URL: url, Fetch: func(ctx context.Context) {
+			addPodcast(ctx, url, false, nil)
+		}})
+	}
+	getFeedPool().Run(context.Background(), tasks)
 	go RefreshEpisodes()
 	return nil
 
@@ -163,8 +165,8 @@ func ExportOmpl(usePodgrabLink bool, baseUrl string) ([]byte, error) {
 	var outlines []model.OpmlOutline
 	for _, podcast := range *podcasts {
 
-		xmlUrl := podcast.URL
-		if usePodgrabLink {
+		xmlUrl := redactPodcastURL(&podcast)
+		if usePodgrabLink || !podcast.HasFeed() {
 			xmlUrl = fmt.Sprintf("%s/podcasts/%s/rss", baseUrl, podcast.ID)
 		}
 
@@ -221,32 +223,63 @@ func getItunesImageUrl(body []byte) string {
 
 }
 
-func AddPodcast(url string) (db.Podcast, error) {
+// AddPodcast subscribes to the feed at url. Credentials are only needed for
+// private feeds and isPrivate marks feeds whose URL holds a secret token.
+func AddPodcast(url string, isPrivate bool, credentials *model.FeedCredentials) (db.Podcast, error) {
+	return addPodcast(context.Background(), url, isPrivate, credentials)
+}
+
+func addPodcast(ctx context.Context, url string, isPrivate bool, credentials *model.FeedCredentials) (db.Podcast, error) {
 	var podcast db.Podcast
 	err := db.GetPodcastByURL(url, &podcast)
 	setting := db.GetOrCreateSetting()
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		data, body, err := FetchURL(url)
+		resp, err := makeConditionalQuery(ctx, url, "", "", credentials)
+		if err != nil {
+			fmt.Println(err.Error())
+			Logger.Errorw("Error adding podcast", err)
+			return db.Podcast{}, err
+		}
+		data, err := ParseFeed(resp.Body)
 		if err != nil {
 			fmt.Println(err.Error())
 			Logger.Errorw("Error adding podcast", err)
 			return db.Podcast{}, err
 		}
 
-		podcast := db.Podcast{
-			Title:   data.Channel.Title,
-			Summary: strip.StripTags(data.Channel.Summary),
-			Author:  data.Channel.Author,
-			Image:   data.Channel.Image.URL,
-			URL:     url,
+		if resp.URL != url && db.GetPodcastByURL(resp.URL, &podcast) == nil {
+			return podcast, &model.PodcastAlreadyExistsError{Url: url}
+		}
+		podcastURL := url
+		if resp.MovedTo != "" {
+			podcastURL = resp.MovedTo
+		}
+
+		encryptedCredentials, err := encryptCredentials(credentials)
+		if err != nil {
+			return db.Podcast{}, pkgErrors.Wrap(err, "failed to encrypt credentials")
 		}
 
-		if podcast.Image == "" {
-			podcast.Image = getItunesImageUrl(body)
+		podcast := db.Podcast{
+			Title:   data.Title,
+			Summary: strip.StripTags(data.Summary),
+			Author:  data.Author,
+			Image:   data.Image,
+			URL:     podcastURL,
+
+			Credentials:    encryptedCredentials,
+			HasCredentials: encryptedCredentials != "",
+			IsPrivate:      isPrivate,
 		}
 
 		err = db.CreatePodcast(&podcast)
-		go DownloadPodcastCoverImage(podcast.Image, podcast.Title)
+		if err == nil && podcastURL != url {
+			err = db.UpdatePodcastURL(podcast.ID, url, podcastURL)
+		}
+		if err == nil {
+			err = updateWebSubHub(&podcast, &data)
+		}
+		go DownloadPodcastCoverImage(podcast.Image, podcast.Title, credentials)
 		if setting.GenerateNFOFile {
 			go CreateNfoFile(&podcast)
 		}
@@ -257,12 +290,120 @@ func AddPodcast(url string) (db.Podcast, error) {
 
 }
 
-func AddPodcastItems(podcast *db.Podcast, newPodcast bool) error {
+// AddPodcastItems fetches the feed of the podcast, adds its new episodes and
+// records the outcome of the fetch in the health of the feed.
+func AddPodcastItems(ctx context.Context, podcast *db.Podcast, newPodcast bool) error {
+	statusCode, err := addPodcastItems(ctx, podcast, newPodcast)
+	recordFeedHealth(podcast, statusCode, err)
+	return err
+}
+
+// oldestFeedItemDate returns the publication date of the oldest item of a
+// feed, zero when no item has a valid one.
+func oldestFeedItemDate(items []model.FeedItem) time.Time {
+	var oldest time.Time
+	for _, item := range items {
+		pubDate, ok := ParsePubDate(item.PubDate)
+		if ok && ((oldest == time.Time{}) || pubDate.Before(oldest)) {
+			oldest = pubDate
+		}
+	}
+	return oldest
+}
+
+// Markup kept in show notes, everything else is reduced to its text.
+var (
+	showNotesTags       = []string{"p", "br", "hr", "a", "b", "strong", "i", "em", "u", "s", "sub", "sup", "ul", "ol", "li", "blockquote", "pre", "code", "h1", "h2", "h3", "h4", "h5", "h6", "span", "div"}
+	showNotesAttributes = []string{"href", "title"}
+)
+
+// sanitizeShowNotes returns the HTML show notes of a feed item, from
+// content:encoded or else the description, stripped of everything not in the
+// allow-list.
+func sanitizeShowNotes(obj *model.FeedItem) string {
+	content := obj.Content
+	if strings.TrimSpace(content) == "" {
+		content = obj.Description
+	}
+	if strings.TrimSpace(content) == "" {
+		return ""
+	}
+	showNotes, err := sanitize.HTMLAllowing(content, showNotesTags, showNotesAttributes)
+	if err != nil {
+		Logger.Errorw("Error sanitizing show notes of "+obj.Title, err)
+		return ""
+	}
+	return strings.TrimSpace(showNotes)
+}
+
+func newPodcastItem(podcast *db.Podcast, obj *model.FeedItem, duration int, pubDate time.Time, downloadStatus db.DownloadStatus) db.PodcastItem {
+	summary := strip.StripTags(obj.Summary)
+	if summary == "" {
+		summary = strip.StripTags(obj.Description)
+	}
+
+	return db.PodcastItem{
+		PodcastID:           podcast.ID,
+		Title:               obj.Title,
+		Summary:             summary,
+		ShowNotes:           sanitizeShowNotes(obj),
+		EpisodeType:         obj.EpisodeType,
+		Duration:            duration,
+		PubDate:             pubDate,
+		FileURL:             obj.Enclosure.URL,
+		EnclosureType:       strings.TrimSpace(obj.Enclosure.Type),
+		EnclosureLength:     parseEnclosureLength(obj.Enclosure.Length),
+		GUID:                obj.GUID,
+		Image:               obj.Image,
+		DownloadStatus:      downloadStatus,
+		Transcripts:         toTranscripts(obj.Transcripts),
+		AlternateEnclosures: toAlternateEnclosures(obj.AlternateEnclosures),
+		ChaptersURL:         obj.ChaptersURL,
+		Chapters:            toChapters(obj.Chapters),
+	}
+}
+
+func addPodcastItems(ctx context.Context, podcast *db.Podcast, newPodcast bool) (int, error) {
 	// fmt.Println("Creating: " + podcast.ID)
-	data, _, err := FetchURL(podcast.URL)
+	resp, err := makeConditionalQuery(ctx, podcast.URL, podcast.ETag, podcast.LastModified, getPodcastCredentials(podcast))
 	if err != nil {
-		// log.Fatal(err)
-		return err
+		if resp != nil {
+			return resp.StatusCode, err
+		}
+		return 0, err
+	}
+	if resp.MovedTo != "" {
+		movePodcast(podcast, resp.MovedTo)
+	}
+	if resp.NotModified {
+		fmt.Println("Feed not modified: " + RedactURL(podcast.URL))
+		return resp.StatusCode, nil
+	}
+
+	feedHash := hashFeedBody(resp.Body)
+	if feedHash == podcast.FeedHash {
+		fmt.Println("Feed unchanged: " + RedactURL(podcast.URL))
+		return resp.StatusCode, db.UpdatePodcastFeedCache(podcast.ID, resp.ETag, resp.LastModified, feedHash)
+	}
+
+	data, err := ParseFeed(resp.Body)
+	if err != nil {
+		return resp.StatusCode, pkgErrors.Wrap(err, "failed to parse feed")
+	}
+	if data.NewFeedURL != "" && data.NewFeedURL != podcast.URL {
+		movePodcast(podcast, data.NewFeedURL)
+	}
+	err = refreshPodcastMetadata(podcast, &data)
+	if err != nil {
+		return resp.StatusCode, pkgErrors.Wrap(err, "failed to refresh podcast metadata")
+	}
+	err = updateRefreshSchedule(podcast, &data)
+	if err != nil {
+		return resp.StatusCode, pkgErrors.Wrap(err, "failed to update refresh schedule for podcast")
+	}
+	err = updateWebSubHub(podcast, &data)
+	if err != nil {
+		return resp.StatusCode, pkgErrors.Wrap(err, "failed to update websub hub for podcast")
 	}
 	setting := db.GetOrCreateSetting()
 	limit := setting.InitialDownloadCount
@@ -270,52 +411,76 @@ func AddPodcastItems(podcast *db.Podcast, newPodcast bool) error {
 	// 	limit = len(data.Channel.Item)
 	// }
 	var allGuids []string
-	for i := 0; i < len(data.Channel.Item); i++ {
-		obj := data.Channel.Item[i]
-		allGuids = append(allGuids, obj.Guid.Text)
+	for i := 0; i < len(data.Items); i++ {
+		obj := data.Items[i]
+		allGuids = append(allGuids, obj.GUID)
 	}
 
 	existingItems, err := db.GetPodcastItemsByPodcastIdAndGUIDs(podcast.ID, allGuids)
 	if err != nil {
-		return pkgErrors.Wrap(err, "failed to get podcast items by podcast id and guids")
+		return resp.StatusCode, pkgErrors.Wrap(err, "failed to get podcast items by podcast id and guids")
 	}
 
-	keyMap := make(map[string]int)
+	keyMap := make(map[string]db.PodcastItem)
 
 	for _, item := range *existingItems {
-		keyMap[item.GUID] = 1
+		keyMap[item.GUID] = item
+	}
+	if !newPodcast {
+		err = linkRegeneratedItems(podcast, data.Items, allGuids, keyMap)
+		if err != nil {
+			return resp.StatusCode, err
+		}
 	}
 	var latestDate = time.Time{}
 	var itemsAdded = make(map[string]string)
-	for i := 0; i < len(data.Channel.Item); i++ {
-		obj := data.Channel.Item[i]
+	var itemsWithChapters []string
+	for i := 0; i < len(data.Items); i++ {
+		obj := data.Items[i]
 		var podcastItem db.PodcastItem
-		_, keyExists := keyMap[obj.Guid.Text]
-		if !keyExists {
-			duration, _ := strconv.Atoi(obj.Duration)
-			toParse := strings.TrimSpace(obj.PubDate)
-
-			pubDate, _ := time.Parse(time.RFC1123Z, toParse)
-			if (pubDate == time.Time{}) {
-				pubDate, _ = time.Parse(time.RFC1123, toParse)
+		existingItem, keyExists := keyMap[obj.GUID]
+		if keyExists {
+			err := addMissingTranscripts(&existingItem, obj.Transcripts)
+			if err != nil {
+				return resp.StatusCode, pkgErrors.Wrap(err, "failed to add transcripts")
+			}
+			err = updateAlternateEnclosures(&existingItem, obj.AlternateEnclosures)
+			if err != nil {
+				return resp.StatusCode, pkgErrors.Wrap(err, "failed to update alternate enclosures")
 			}
-			if (pubDate == time.Time{}) {
-				//	RFC1123     = "Mon, 02 Jan 2006 15:04:05 MST"
-				modifiedRFC1123 := "Mon, 2 Jan 2006 15:04:05 MST"
-				pubDate, _ = time.Parse(modifiedRFC1123, toParse)
+			fetchChapters, err := addMissingChapters(&existingItem, &obj)
+			if err != nil {
+				return resp.StatusCode, pkgErrors.Wrap(err, "failed to add chapters")
 			}
-			if (pubDate == time.Time{}) {
-				//	RFC1123Z    = "Mon, 02 Jan 2006 15:04:05 -0700" // RFC1123 with numeric zone
-				modifiedRFC1123Z := "Mon, 2 Jan 2006 15:04:05 -0700"
-				pubDate, _ = time.Parse(modifiedRFC1123Z, toParse)
+			if fetchChapters {
+				itemsWithChapters = append(itemsWithChapters, existingItem.ID)
 			}
-			if (pubDate == time.Time{}) {
-				//	RFC1123Z    = "Mon, 02 Jan 2006 15:04:05 -0700" // RFC1123 with numeric zone
-				modifiedRFC1123Z := "Mon, 02 Jan 2006 15:04:05 -0700"
-				pubDate, _ = time.Parse(modifiedRFC1123Z, toParse)
+			if existingItem.EnclosureType == "" && strings.TrimSpace(obj.Enclosure.Type) != "" {
+				err := db.UpdatePodcastItemEnclosureType(existingItem.ID, strings.TrimSpace(obj.Enclosure.Type))
+				if err != nil {
+					return resp.StatusCode, pkgErrors.Wrap(err, "failed to update enclosure type")
+				}
 			}
-
-			if (pubDate == time.Time{}) {
+			if existingItem.EnclosureLength == 0 {
+				if length := parseEnclosureLength(obj.Enclosure.Length); length > 0 {
+					err := db.UpdatePodcastItemEnclosureLength(existingItem.ID, length)
+					if err != nil {
+						return resp.StatusCode, pkgErrors.Wrap(err, "failed to update enclosure length")
+					}
+				}
+			}
+			if existingItem.ShowNotes == "" {
+				if showNotes := sanitizeShowNotes(&obj); showNotes != "" {
+					err := db.UpdatePodcastItemShowNotes(existingItem.ID, showNotes)
+					if err != nil {
+						return resp.StatusCode, pkgErrors.Wrap(err, "failed to update show notes")
+					}
+				}
+			}
+		} else {
+			duration, _ := ParseDuration(obj.Duration)
+			pubDate, ok := ParsePubDate(obj.PubDate)
+			if !ok {
 				fmt.Printf("Cant format date : %s", obj.PubDate)
 			}
 
@@ -346,38 +511,125 @@ func AddPodcastItems(podcast *db.Podcast, newPodcast bool) error {
 				downloadStatus = db.Deleted
 			}
 
-			summary := strip.StripTags(obj.Summary)
-			if summary == "" {
-				summary = strip.StripTags(obj.Description)
-			}
-
-			podcastItem = db.PodcastItem{
-				PodcastID:      podcast.ID,
-				Title:          obj.Title,
-				Summary:        summary,
-				EpisodeType:    obj.EpisodeType,
-				Duration:       duration,
-				PubDate:        pubDate,
-				FileURL:        obj.Enclosure.URL,
-				GUID:           obj.Guid.Text,
-				Image:          obj.Image.Href,
-				DownloadStatus: downloadStatus,
-			}
+			podcastItem = newPodcastItem(podcast, &obj, duration, pubDate, downloadStatus)
 
 			err := db.CreatePodcastItem(&podcastItem)
 			if err != nil {
-				return pkgErrors.Wrap(err, "failed to create podcast item")
+				return resp.StatusCode, pkgErrors.Wrap(err, "failed to create podcast item")
 			}
 			itemsAdded[podcastItem.ID] = podcastItem.FileURL
+			if podcastItem.ChaptersURL != "" {
+				itemsWithChapters = append(itemsWithChapters, podcastItem.ID)
+			}
+		}
+	}
+	if len(itemsWithChapters) > 0 {
+		go fetchChaptersForItems(itemsWithChapters)
+	}
+	// An empty feed is more likely broken than emptied by its publisher, and
+	// only the episodes within the dates the feed covers can be missing from
+	// it.
+	if oldestDate := oldestFeedItemDate(data.Items); len(allGuids) > 0 && (oldestDate != time.Time{}) {
+		removed, err := db.UpdatePodcastItemsRemovedUpstream(podcast.ID, allGuids, oldestDate, time.Now())
+		if err != nil {
+			return resp.StatusCode, pkgErrors.Wrap(err, "failed to update episodes removed upstream")
+		}
+		if removed > 0 {
+			fmt.Printf("%d episodes removed upstream: %s\n", removed, podcast.Title)
 		}
 	}
 	if (latestDate != time.Time{}) {
 		err := db.UpdateLastEpisodeDateForPodcast(podcast.ID, latestDate)
 		if err != nil {
-			return pkgErrors.Wrap(err, "failed to update last episode date for podcast")
+			return resp.StatusCode, pkgErrors.Wrap(err, "failed to update last episode date for podcast")
 		}
+		podcast.LastEpisode = &latestDate
 	}
-	return err
+
+	err = db.UpdatePodcastFeedCache(podcast.ID, resp.ETag, resp.LastModified, feedHash)
+	if err != nil {
+		return resp.StatusCode, pkgErrors.Wrap(err, "failed to update feed cache for podcast")
+	}
+	return resp.StatusCode, nil
+}
+
+// refreshPodcastMetadata updates the title, summary, author and image of the
+// podcast when the feed changed them, logging every change. The cover and
+// album.nfo are written again, in the folder of the new title if it changed;
+// episodes already downloaded stay where they are.
+func refreshPodcastMetadata(podcast *db.Podcast, feed *model.Feed) error {
+	oldTitle, oldImage := podcast.Title, podcast.Image
+
+	var changes []db.PodcastMetadataChange
+	update := func(field string, current *string, value string) {
+		value = strings.TrimSpace(value)
+		if value == "" || value == *current {
+			return
+		}
+		changes = append(changes, db.PodcastMetadataChange{
+			PodcastID: podcast.ID,
+			Field:     field,
+			OldValue:  *current,
+			NewValue:  value,
+		})
+		*current = value
+	}
+	update("Title", &podcast.Title, feed.Title)
+	update("Summary", &podcast.Summary, strip.StripTags(feed.Summary))
+	update("Author", &podcast.Author, feed.Author)
+	update("Image", &podcast.Image, feed.Image)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	for _, change := range changes {
+		fmt.Println("Podcast " + change.Field + " changed: " + oldTitle)
+	}
+	err := db.UpdatePodcastMetadata(podcast, changes)
+	if err != nil {
+		return err
+	}
+
+	if podcast.Title != oldTitle || podcast.Image != oldImage {
+		if podcast.Image != oldImage {
+			oldCover, err := GetPodcastLocalImagePath(oldImage, oldTitle)
+			if err == nil && FileExists(oldCover) {
+				DeleteFile(oldCover)
+			}
+		}
+		go DownloadPodcastCoverImage(podcast.Image, podcast.Title, getPodcastCredentials(podcast))
+	}
+	if db.GetOrCreateSetting().GenerateNFOFile {
+		updated := *podcast
+		go CreateNfoFile(&updated)
+	}
+	return nil
+}
+
+// movePodcast follows a feed which was moved by its publisher, either through
+// a permanent redirect or an itunes:new-feed-url. The previous URL is kept so
+// that the podcast can still be matched by it.
+func movePodcast(podcast *db.Podcast, newURL string) {
+	parsed, err := neturl.Parse(newURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		fmt.Println("Ignoring invalid new feed url: " + RedactURL(newURL))
+		return
+	}
+
+	var existing db.Podcast
+	err = db.GetPodcastByURL(newURL, &existing)
+	if err == nil && existing.ID != podcast.ID {
+		fmt.Println("Not moving " + RedactURL(podcast.URL) + ", " + RedactURL(newURL) + " belongs to " + existing.Title)
+		return
+	}
+
+	fmt.Println("Feed moved: " + RedactURL(podcast.URL) + " -> " + RedactURL(newURL))
+	err = db.UpdatePodcastURL(podcast.ID, podcast.URL, newURL)
+	if err != nil {
+		Logger.Errorw("Error updating feed url of "+podcast.Title, err)
+		return
+	}
+	podcast.URL = newURL
 }
 
 func UpdateAllFileSizes() {
@@ -390,12 +642,111 @@ func UpdateAllFileSizes() {
 		if item.DownloadStatus == db.Downloaded {
 			size, _ = GetFileSize(item.DownloadPath)
 		} else {
-			size, _ = GetFileSizeFromUrl(item.FileURL)
+			size, _ = GetFileSizeFromUrl(item.FileURL, getPodcastCredentials(&item.Podcast))
 		}
 		db.UpdatePodcastItemFileSize(item.ID, size)
 	}
 }
 
+// ReparseDurationsAndDates fetches the feeds of podcasts having episodes
+// without a duration or a publication date, and fills the missing values in
+// from the feed using the current parsers. Each podcast is only reparsed once,
+// so that episodes whose feed has no usable value are not fetched again and
+// again, but the feeds which could not be fetched are retried by the next
+// runs. The job is done for good once every podcast was reparsed.
+func ReparseDurationsAndDates() error {
+	const JOB_NAME = "ReparseDurationsAndDates"
+	const MIGRATION_NAME = "2026_10_17_06_57_ReparseDurationsAndDates"
+	if db.IsMigrationDone(MIGRATION_NAME) {
+		return nil
+	}
+	lock := db.GetLock(JOB_NAME)
+	if lock.IsLocked() {
+		fmt.Println(JOB_NAME + " is locked")
+		return nil
+	}
+	db.Lock(JOB_NAME, 60)
+	defer db.Unlock(JOB_NAME)
+
+	items, err := db.GetAllPodcastItemsWithoutDurationOrDate()
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to get podcast items without duration or date")
+	}
+
+	byPodcast := make(map[string][]db.PodcastItem)
+	for _, item := range *items {
+		byPodcast[item.PodcastID] = append(byPodcast[item.PodcastID], item)
+	}
+
+	failed := 0
+	for podcastId, podcastItems := range byPodcast {
+		podcastMigration := MIGRATION_NAME + "_" + podcastId
+		if !podcastItems[0].Podcast.HasFeed() || db.IsMigrationDone(podcastMigration) {
+			continue
+		}
+		err := reparsePodcastItems(&podcastItems[0].Podcast, podcastItems)
+		if err != nil {
+			Logger.Errorw("Error reparsing episodes of podcast "+podcastId, err)
+			failed++
+			continue
+		}
+		err = db.SaveMigration(podcastMigration)
+		if err != nil {
+			return pkgErrors.Wrap(err, "failed to save reparsed podcast")
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to reparse episodes of %d podcasts, retrying on the next run", failed)
+	}
+	return db.SaveMigration(MIGRATION_NAME)
+}
+
+func reparsePodcastItems(podcast *db.Podcast, podcastItems []db.PodcastItem) error {
+	body, err := makeQuery(podcast.URL, getPodcastCredentials(podcast))
+	if err != nil {
+		return err
+	}
+	data, err := ParseFeed(body)
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to parse feed")
+	}
+
+	feedItems := make(map[string]model.FeedItem)
+	for _, feedItem := range data.Items {
+		feedItems[feedItem.GUID] = feedItem
+	}
+
+	var latestDate = time.Time{}
+	for _, item := range podcastItems {
+		feedItem, ok := feedItems[item.GUID]
+		if !ok {
+			continue
+		}
+		duration, pubDate := item.Duration, item.PubDate
+		if duration <= 0 {
+			duration, _ = ParseDuration(feedItem.Duration)
+		}
+		if (pubDate == time.Time{}) {
+			pubDate, _ = ParsePubDate(feedItem.PubDate)
+		}
+		if duration == item.Duration && pubDate.Equal(item.PubDate) {
+			continue
+		}
+		err := db.UpdatePodcastItemDurationAndPubDate(item.ID, duration, pubDate)
+		if err != nil {
+			return pkgErrors.Wrap(err, "failed to update duration and publication date")
+		}
+		if latestDate.Before(pubDate) {
+			latestDate = pubDate
+		}
+	}
+
+	if podcast.LastEpisode != nil && latestDate.After(*podcast.LastEpisode) {
+		return db.UpdateLastEpisodeDateForPodcast(podcast.ID, latestDate)
+	}
+	return nil
+}
+
 func SetPodcastItemAsQueuedForDownload(id string) error {
 	var podcastItem db.PodcastItem
 	err := db.GetPodcastItemById(id, &podcastItem)
@@ -403,10 +754,49 @@ func SetPodcastItemAsQueuedForDownload(id string) error {
 		return err
 	}
 	podcastItem.DownloadStatus = db.NotDownloaded
+	podcastItem.DownloadAttempts = 0
+	podcastItem.NextDownloadRetry = nil
 
 	return db.UpdatePodcastItem(&podcastItem)
 }
 
+// Failed downloads are retried after downloadBackoffBase, doubling up to
+// downloadBackoffMax, until DOWNLOAD_MAX_ATTEMPTS attempts failed.
+const (
+	downloadBackoffBase        = 15 * time.Minute
+	downloadBackoffMax         = 24 * time.Hour
+	defaultDownloadMaxAttempts = 5
+)
+
+// downloadRetryDelay is how long to wait before downloading an episode again
+// after the given number of failed attempts. It doubles with every attempt.
+func downloadRetryDelay(attempts int) time.Duration {
+	delay := downloadBackoffBase
+	for i := 1; i < attempts && delay < downloadBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > downloadBackoffMax {
+		delay = downloadBackoffMax
+	}
+	return delay
+}
+
+// recordDownloadFailure marks the episode as failed after its attempts-th
+// failed download, scheduling a retry unless DOWNLOAD_MAX_ATTEMPTS is
+// reached.
+func recordDownloadFailure(podcastItem *db.PodcastItem, attempts int, downloadErr error) {
+	Logger.Errorw("Error downloading "+podcastItem.Title, downloadErr)
+	var nextRetry *time.Time
+	if attempts < getPositiveIntEnv("DOWNLOAD_MAX_ATTEMPTS", defaultDownloadMaxAttempts) {
+		next := time.Now().Add(downloadRetryDelay(attempts))
+		nextRetry = &next
+	}
+	err := db.UpdatePodcastItemDownloadFailure(podcastItem.ID, redactError(downloadErr).Error(), attempts, nextRetry)
+	if err != nil {
+		Logger.Errorw("Error recording failed download of "+podcastItem.Title, err)
+	}
+}
+
 func DownloadMissingImages() error {
 	setting := db.GetOrCreateSetting()
 	if !setting.DownloadEpisodeImages {
@@ -423,6 +813,20 @@ func DownloadMissingImages() error {
 	return nil
 }
 
+// DownloadMissingTranscripts saves the transcripts of downloaded episodes
+// which were not saved when the episode was, like the ones the feed added
+// later.
+func DownloadMissingTranscripts() error {
+	items, err := db.GetAllPodcastItemsWithoutTranscripts()
+	if err != nil {
+		return err
+	}
+	for _, item := range *items {
+		downloadTranscriptsLocally(item.ID)
+	}
+	return nil
+}
+
 func downloadImageLocally(podcastItemId string) error {
 	var podcastItem db.PodcastItem
 	err := db.GetPodcastItemById(podcastItemId, &podcastItem)
@@ -430,7 +834,7 @@ func downloadImageLocally(podcastItemId string) error {
 		return err
 	}
 
-	path, err := DownloadImage(podcastItem.Image, podcastItem.ID, podcastItem.Podcast.Title)
+	path, err := DownloadImage(podcastItem.Image, podcastItem.ID, podcastItem.Podcast.Title, getPodcastCredentials(&podcastItem.Podcast))
 	if err != nil {
 		return err
 	}
@@ -471,6 +875,10 @@ func SetPodcastItemAsDownloaded(id string, location string) error {
 	podcastItem.DownloadDate = time.Now()
 	podcastItem.DownloadPath = location
 	podcastItem.DownloadStatus = db.Downloaded
+	podcastItem.IntegrityError = ""
+	podcastItem.DownloadError = ""
+	podcastItem.DownloadAttempts = 0
+	podcastItem.NextDownloadRetry = nil
 
 	return db.UpdatePodcastItem(&podcastItem)
 }
@@ -502,7 +910,7 @@ func SetAllEpisodesToDownload(podcastId string) error {
 	if err != nil {
 		return err
 	}
-	AddPodcastItems(&podcast, false)
+	AddPodcastItems(context.Background(), &podcast, false)
 	return db.SetAllEpisodesToDownload(podcastId)
 }
 
@@ -524,6 +932,9 @@ func GetPodcastPrefix(item *db.PodcastItem, setting *db.Setting) string {
 	}
 	return prefix
 }
+
+// DownloadMissingEpisodes queues the episodes waiting to be downloaded,
+// including the failed ones due for a retry.
 func DownloadMissingEpisodes() error {
 	const JOB_NAME = "DownloadMissingEpisodes"
 	lock := db.GetLock(JOB_NAME)
@@ -532,7 +943,7 @@ func DownloadMissingEpisodes() error {
 		return nil
 	}
 	db.Lock(JOB_NAME, 120)
-	setting := db.GetOrCreateSetting()
+	defer db.Unlock(JOB_NAME)
 
 	data, err := db.GetAllPodcastItemsToBeDownloaded()
 	if err != nil {
@@ -540,25 +951,48 @@ func DownloadMissingEpisodes() error {
 	}
 
 	fmt.Println("Processing episodes: ", strconv.Itoa(len(*data)))
+	for _, item := range *data {
+		EnqueueDownload(&item, DownloadPriorityAuto)
+	}
+	return nil
+}
+
+// downloadQueuedEpisode downloads the episode of a job of the download
+// queue. Episodes which were downloaded or deleted since they were queued
+// are skipped.
+func downloadQueuedEpisode(job *DownloadJob, progress downloadProgress) error {
+	var podcastItem db.PodcastItem
+	err := db.GetPodcastItemById(job.PodcastItemID, &podcastItem)
 	if err != nil {
 		return err
 	}
-	var wg sync.WaitGroup
-	for index, item := range *data {
-		wg.Add(1)
-		go func(item db.PodcastItem, setting db.Setting) {
-			defer wg.Done()
-			url, _ := Download(item.FileURL, item.Title, item.Podcast.Title, GetPodcastPrefix(&item, &setting))
-			SetPodcastItemAsDownloaded(item.ID, url)
-		}(item, *setting)
+	if (podcastItem.DownloadStatus != db.NotDownloaded && podcastItem.DownloadStatus != db.Failed) || podcastItem.FileURL == "" || podcastItem.Podcast.LocalFolder != "" {
+		job.Status = DownloadJobSkipped
+		return nil
+	}
 
-		if index%setting.MaxDownloadConcurrency == 0 {
-			wg.Wait()
-		}
+	err = db.UpdatePodcastItemDownloadStatus(podcastItem.ID, db.Downloading)
+	if err != nil {
+		return err
 	}
-	wg.Wait()
-	db.Unlock(JOB_NAME)
-	return nil
+	setting := db.GetOrCreateSetting()
+	enclosure := selectEnclosure(&podcastItem, setting)
+	url, err := Download(enclosure.URL, podcastItem.Title, podcastItem.Podcast.Title, GetPodcastPrefix(&podcastItem, setting), enclosure.Type, enclosure.Length, getPodcastCredentials(&podcastItem.Podcast), progress)
+	if err != nil {
+		recordDownloadFailure(&podcastItem, podcastItem.DownloadAttempts+1, err)
+		return err
+	}
+	err = SetPodcastItemAsDownloaded(podcastItem.ID, url)
+	if err == nil {
+		err = db.UpdatePodcastItemDownloadMediaType(podcastItem.ID, enclosure.Type)
+	}
+
+	if setting.DownloadEpisodeImages {
+		downloadImageLocally(podcastItem.ID)
+	}
+	downloadTranscriptsLocally(podcastItem.ID)
+	readChaptersFromFile(podcastItem.ID)
+	return err
 }
 func CheckMissingFiles() error {
 	data, err := db.GetAllPodcastItemsAlreadyDownloaded()
@@ -569,9 +1003,14 @@ func CheckMissingFiles() error {
 		return err
 	}
 	for _, item := range *data {
+		// Local episodes are removed by the scan of their folder.
+		if item.Podcast.LocalFolder != "" {
+			continue
+		}
 		fileExists := FileExists(item.DownloadPath)
 		if !fileExists {
-			if setting.DontDownloadDeletedFromDisk {
+			// Episodes without an enclosure cannot be downloaded again.
+			if setting.DontDownloadDeletedFromDisk || item.FileURL == "" {
 				SetPodcastItemAsNotDownloaded(item.ID, db.Deleted)
 			} else {
 				SetPodcastItemAsNotDownloaded(item.ID, db.NotDownloaded)
@@ -589,6 +1028,9 @@ func DeleteEpisodeFile(podcastItemId string) error {
 	if err != nil {
 		return err
 	}
+	if podcastItem.Podcast.LocalFolder != "" {
+		return errLocalPodcastFile
+	}
 
 	err = DeleteFile(podcastItem.DownloadPath)
 
@@ -600,9 +1042,13 @@ func DeleteEpisodeFile(podcastItemId string) error {
 	if podcastItem.LocalImage != "" {
 		go DeleteFile(podcastItem.LocalImage)
 	}
+	deleteTranscriptFiles(&podcastItem)
 
 	return SetPodcastItemAsNotDownloaded(podcastItem.ID, db.Deleted)
 }
+
+// DownloadSingleEpisode queues the download of an episode ahead of the
+// automatic ones.
 func DownloadSingleEpisode(podcastItemId string) error {
 	var podcastItem db.PodcastItem
 	err := db.GetPodcastItemById(podcastItemId, &podcastItem)
@@ -611,22 +1057,19 @@ func DownloadSingleEpisode(podcastItemId string) error {
 	if err != nil {
 		return err
 	}
+	if podcastItem.Podcast.LocalFolder != "" {
+		return errLocalPodcastFile
+	}
+	if podcastItem.FileURL == "" {
+		return errors.New("episode has no enclosure to download")
+	}
 
-	setting := db.GetOrCreateSetting()
-	SetPodcastItemAsQueuedForDownload(podcastItemId)
-
-	url, err := Download(podcastItem.FileURL, podcastItem.Title, podcastItem.Podcast.Title, GetPodcastPrefix(&podcastItem, setting))
-
+	err = SetPodcastItemAsQueuedForDownload(podcastItemId)
 	if err != nil {
-		fmt.Println(err.Error())
 		return err
 	}
-	err = SetPodcastItemAsDownloaded(podcastItem.ID, url)
-
-	if setting.DownloadEpisodeImages {
-		downloadImageLocally(podcastItem.ID)
-	}
-	return err
+	EnqueueDownload(&podcastItem, DownloadPriorityManual)
+	return nil
 }
 
 func RefreshEpisodes() error {
@@ -636,14 +1079,25 @@ func RefreshEpisodes() error {
 	if err != nil {
 		return err
 	}
+	var tasks []feedTask
 	for _, item := range data {
-		isNewPodcast := item.LastEpisode == nil
-		if isNewPodcast {
-			fmt.Println(item.Title)
-			db.ForceSetLastEpisodeDate(item.ID)
+		if !item.HasFeed() || !isPodcastDue(&item) {
+			continue
 		}
-		AddPodcastItems(&item, isNewPodcast)
+		podcast := item
+		tasks = append(tasks, feedTask{URL: podcast.URL, Fetch: func(ctx context.Context) {
+			isNewPodcast := podcast.LastEpisode == nil
+			if isNewPodcast {
+				fmt.Println(podcast.Title)
+				db.ForceSetLastEpisodeDate(podcast.ID)
+			}
+			err := AddPodcastItems(ctx, &podcast, isNewPodcast)
+			if err != nil {
+				Logger.Errorw("Error refreshing podcast "+podcast.Title, err)
+			}
+		}})
 	}
+	getFeedPool().Run(context.Background(), tasks)
 	//	setting := db.GetOrCreateSetting()
 
 	go DownloadMissingEpisodes()
@@ -658,6 +1112,9 @@ func DeletePodcastEpisodes(id string) error {
 	if err != nil {
 		return err
 	}
+	if podcast.LocalFolder != "" {
+		return errLocalPodcastFile
+	}
 	var podcastItems []db.PodcastItem
 
 	err = db.GetAllPodcastItemsByPodcastId(id, &podcastItems)
@@ -669,6 +1126,7 @@ func DeletePodcastEpisodes(id string) error {
 		if item.LocalImage != "" {
 			DeleteFile(item.LocalImage)
 		}
+		deleteTranscriptFiles(&item)
 		SetPodcastItemAsNotDownloaded(item.ID, db.Deleted)
 
 	}
@@ -688,27 +1146,35 @@ func DeletePodcast(id string, deleteFiles bool) error {
 	if err != nil {
 		return err
 	}
+	// The files of local podcasts belong to their folder, not to Podgrab.
+	if podcast.LocalFolder != "" {
+		deleteFiles = false
+	}
 	for _, item := range podcastItems {
 		if deleteFiles {
 			DeleteFile(item.DownloadPath)
 			if item.LocalImage != "" {
 				DeleteFile(item.LocalImage)
 			}
+			deleteTranscriptFiles(&item)
 
 		}
 		db.DeletePodcastItemById(item.ID)
 
 	}
 
-	err = deletePodcastFolder(podcast.Title)
-	if err != nil {
-		return err
+	if podcast.LocalFolder == "" {
+		err = deletePodcastFolder(podcast.Title)
+		if err != nil {
+			return err
+		}
 	}
 
 	err = db.DeletePodcastById(id)
 	if err != nil {
 		return err
 	}
+	go unsubscribeWebSub(&podcast)
 	return nil
 
 }
@@ -722,27 +1188,106 @@ func DeleteTag(id string) error {
 
 }
 
-func makeQuery(url string) ([]byte, error) {
+// feedResponse is the result of fetching a feed, along with the caching
+// validators returned by the server.
+type feedResponse struct {
+	Body         []byte
+	ETag         string
+	LastModified string
+	NotModified  bool
+	StatusCode   int
+	// URL is the location the response was served from, after redirects.
+	URL string
+	// MovedTo is the final location of the url when every redirect followed
+	// to reach it was permanent.
+	MovedTo string
+}
 
-	fmt.Println(url)
-	req, err := http.NewRequest("GET", url, nil)
+func makeQuery(url string, credentials *model.FeedCredentials) ([]byte, error) {
+	resp, err := makeConditionalQuery(context.Background(), url, "", "", credentials)
 	if err != nil {
 		return nil, err
 	}
+	return resp.Body, nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// makeConditionalQuery fetches the url, sending If-None-Match and
+// If-Modified-Since when the corresponding validators are known.
+// A 304 response is reported through NotModified with an empty body.
+// Error statuses are returned as an error along with the response.
+// The whole request, body included, is bounded by the feed fetch timeout.
+func makeConditionalQuery(ctx context.Context, url string, etag string, lastModified string, credentials *model.FeedCredentials) (*feedResponse, error) {
+
+	fmt.Println(RedactURL(url))
+	req, err := createGetRequest(url, credentials)
 	if err != nil {
 		return nil, err
 	}
+	ctx, cancel := context.WithTimeout(ctx, feedFetchTimeout())
+	defer cancel()
+	req = req.WithContext(ctx)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	redirected, permanent := false, true
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if req.Response == nil || !isPermanentRedirect(req.Response.StatusCode) {
+				permanent = false
+			}
+			redirected = true
+			dropCredentialsOnRedirect(req, via, credentials)
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, redactError(err)
+	}
 
 	defer resp.Body.Close()
 	fmt.Println("Response status:", resp.Status)
+
+	toReturn := &feedResponse{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		StatusCode:   resp.StatusCode,
+		URL:          resp.Request.URL.String(),
+	}
+	if redirected && permanent {
+		toReturn.MovedTo = resp.Request.URL.String()
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		toReturn.NotModified = true
+		return toReturn, nil
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return toReturn, pkgErrors.Errorf("unexpected response status: %s", resp.Status)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, pkgErrors.Wrap(err, "failed to read response body")
 	}
+	toReturn.Body = body
+
+	return toReturn, nil
+}
 
-	return body, nil
+func isPermanentRedirect(statusCode int) bool {
+	return statusCode == http.StatusMovedPermanently || statusCode == http.StatusPermanentRedirect
+}
+
+func hashFeedBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
 }
 
 func GetSearchFromItunes(pod model.ItunesSingleResult) *model.CommonSearchResultModel {
@@ -773,7 +1318,15 @@ func GetSearchFromPodcastIndex(pod *podcastindex.Podcast) *model.CommonSearchRes
 
 func UpdateSettings(downloadOnAdd bool, initialDownloadCount int, autoDownload bool,
 	appendDateToFileName bool, appendEpisodeNumberToFileName bool, darkMode bool, downloadEpisodeImages bool,
-	generateNFOFile bool, dontDownloadDeletedFromDisk bool, baseUrl string, maxDownloadConcurrency int, userAgent string) error {
+	generateNFOFile bool, dontDownloadDeletedFromDisk bool, baseUrl string, maxDownloadConcurrency int, userAgent string,
+	preferredCodec string, maxBitrate int, preferredMediaKind string) error {
+	preferredMediaKind, err := normalizeMediaKind(preferredMediaKind)
+	if err != nil {
+		return err
+	}
+	if maxBitrate < 0 {
+		maxBitrate = 0
+	}
 	setting := db.GetOrCreateSetting()
 
 	setting.AutoDownload = autoDownload
@@ -788,14 +1341,28 @@ func UpdateSettings(downloadOnAdd bool, initialDownloadCount int, autoDownload b
 	setting.BaseUrl = baseUrl
 	setting.MaxDownloadConcurrency = maxDownloadConcurrency
 	setting.UserAgent = userAgent
+	setting.PreferredCodec = strings.ToLower(strings.TrimSpace(preferredCodec))
+	setting.MaxBitrate = maxBitrate
+	setting.PreferredMediaKind = preferredMediaKind
 
-	return db.UpdateSettings(setting)
+	err = db.UpdateSettings(setting)
+	if err != nil {
+		return err
+	}
+	getDownloadQueue().SetWorkers(maxDownloadConcurrency)
+	return nil
 }
 
 func UnlockMissedJobs() {
 	db.UnlockMissedJobs()
 }
 
+// ResetInterruptedDownloads queues again the episodes which were being
+// downloaded when the application stopped.
+func ResetInterruptedDownloads() error {
+	return db.ResetInterruptedDownloads()
+}
+
 func AddTag(label, description string) (db.Tag, error) {
 
 	tag, err := db.GetTagByLabel(label)
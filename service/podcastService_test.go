@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/model"
+)
+
+func TestSanitizeShowNotes(t *testing.T) {
+	tests := []struct {
+		name string
+		item model.FeedItem
+		want string
+	}{
+		{
+			name: "content",
+			item: model.FeedItem{
+				Description: "Description",
+				Content:     ` <p class="intro">Notes with <a href="https://example.com" rel="sponsored">a link</a></p> `,
+			},
+			want: `<p>Notes with <a href="https://example.com">a link</a></p>`,
+		},
+		{
+			name: "description when there is no content",
+			item: model.FeedItem{Description: "<p>Description</p>", Content: "  "},
+			want: "<p>Description</p>",
+		},
+		{
+			name: "markup outside the allow-list",
+			item: model.FeedItem{Content: `<table><tr><td>Cell</td></tr></table><img src="https://example.com/x.png"><script>alert(1)</script><u>End</u>`},
+			want: "Cell<u>End</u>",
+		},
+		{
+			name: "no notes",
+			item: model.FeedItem{},
+			want: "",
+		},
+	}
+	for _, test := range tests {
+		if got := sanitizeShowNotes(&test.item); got != test.want {
+			t.Errorf("%s: sanitizeShowNotes() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReparseDurationsAndDates(t *testing.T) {
+	setupTestDB(t)
+
+	feed := func(guid string) string {
+		return `<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel><title>Show</title>
+			<item><title>Episode</title><guid>` + guid + `</guid><pubDate>Mon, 01 Jan 2024 10:00:00 GMT</pubDate><itunes:duration>1:00</itunes:duration></item>
+		</channel></rss>`
+	}
+	requests := map[string]int{}
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if r.URL.Path == "/down.xml" && failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(feed(r.URL.Path)))
+	}))
+	defer server.Close()
+
+	items := map[string]string{}
+	for _, feedPath := range []string{"/up.xml", "/down.xml"} {
+		podcast := db.Podcast{Title: feedPath, URL: server.URL + feedPath}
+		if err := db.CreatePodcast(&podcast); err != nil {
+			t.Fatal(err)
+		}
+		item := db.PodcastItem{PodcastID: podcast.ID, Title: "Episode", GUID: feedPath}
+		if err := db.CreatePodcastItem(&item); err != nil {
+			t.Fatal(err)
+		}
+		items[feedPath] = item.ID
+	}
+	reparsed := func(feedPath string) bool {
+		var item db.PodcastItem
+		if err := db.GetPodcastItemById(items[feedPath], &item); err != nil {
+			t.Fatal(err)
+		}
+		return item.Duration == 60 && !item.PubDate.IsZero()
+	}
+
+	// A feed which cannot be fetched keeps the job from being done.
+	if err := ReparseDurationsAndDates(); err == nil {
+		t.Error("ReparseDurationsAndDates() with a failing feed succeeded")
+	}
+	if !reparsed("/up.xml") || reparsed("/down.xml") {
+		t.Errorf("after the first run reparsed up: %v, down: %v, want true, false", reparsed("/up.xml"), reparsed("/down.xml"))
+	}
+
+	// The next run only retries it.
+	failing = false
+	if err := ReparseDurationsAndDates(); err != nil {
+		t.Errorf("ReparseDurationsAndDates() error = %v", err)
+	}
+	if !reparsed("/down.xml") {
+		t.Error("the feed which failed was not reparsed on the next run")
+	}
+	if requests["/up.xml"] != 1 || requests["/down.xml"] != 2 {
+		t.Errorf("requests %v, want up.xml once and down.xml twice", requests)
+	}
+
+	// Once every podcast was reparsed, the job is done for good.
+	if err := db.UpdatePodcastItemDurationAndPubDate(items["/up.xml"], 0, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReparseDurationsAndDates(); err != nil {
+		t.Errorf("ReparseDurationsAndDates() error = %v", err)
+	}
+	if requests["/up.xml"] != 1 || requests["/down.xml"] != 2 {
+		t.Errorf("requests %v after the job was done, want no more", requests)
+	}
+}
+
+func TestRemovedUpstream(t *testing.T) {
+	setupTestDB(t)
+
+	episodes := map[string]string{
+		"a": "Mon, 01 Jan 2024 10:00:00 GMT",
+		"b": "Tue, 02 Jan 2024 10:00:00 GMT",
+		"c": "Wed, 03 Jan 2024 10:00:00 GMT",
+		"d": "Thu, 04 Jan 2024 10:00:00 GMT",
+		"e": "Fri, 05 Jan 2024 10:00:00 GMT",
+	}
+	var current []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `<rss version="2.0"><channel><title>Show</title>`
+		for _, guid := range current {
+			body += `<item><title>Episode ` + guid + `</title><guid>` + guid + `</guid><pubDate>` + episodes[guid] + `</pubDate>` +
+				`<enclosure url="https://example.com/` + guid + `.mp3" type="audio/mpeg"/></item>`
+		}
+		w.Write([]byte(body + `</channel></rss>`))
+	}))
+	defer server.Close()
+
+	podcast := db.Podcast{Title: "Show", URL: server.URL}
+	if err := db.CreatePodcast(&podcast); err != nil {
+		t.Fatal(err)
+	}
+	refresh := func(guids ...string) {
+		t.Helper()
+		current = guids
+		if err := AddPodcastItems(context.Background(), &podcast, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	removed := func() []string {
+		t.Helper()
+		var items []db.PodcastItem
+		if err := db.GetAllPodcastItemsByPodcastId(podcast.ID, &items); err != nil {
+			t.Fatal(err)
+		}
+		guids := []string{}
+		for _, item := range items {
+			if item.IsRemovedUpstream {
+				guids = append(guids, item.GUID)
+			}
+		}
+		sort.Strings(guids)
+		return guids
+	}
+
+	refresh("d", "c", "b", "a")
+	if got := removed(); len(got) != 0 {
+		t.Fatalf("removed upstream %v, want none", got)
+	}
+
+	// The feed now only carries its latest episodes: the ones older than
+	// the oldest it carries are not known to be removed, c is.
+	refresh("e", "d", "b")
+	if got := removed(); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("removed upstream %v, want [c]", got)
+	}
+
+	// Episodes which come back are not removed anymore.
+	refresh("e", "d", "c")
+	if got := removed(); len(got) != 0 {
+		t.Errorf("removed upstream %v after c came back, want none", got)
+	}
+
+	// Without dates, the feed does not tell what it covers.
+	for guid := range episodes {
+		episodes[guid] = ""
+	}
+	refresh("e")
+	if got := removed(); len(got) != 0 {
+		t.Errorf("removed upstream %v from a feed without dates, want none", got)
+	}
+}
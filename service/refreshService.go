@@ -0,0 +1,166 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/model"
+)
+
+const (
+	feedBackoffBase = 30 * time.Minute
+	feedBackoffMax  = 24 * time.Hour
+
+	// Bounds of the refresh interval computed from the release cadence.
+	maxRefreshInterval = 24 * time.Hour
+	// Shows which are complete, or which have not released anything for
+	// inactiveAfter, are only checked every inactiveRefreshInterval.
+	inactiveAfter           = 180 * 24 * time.Hour
+	inactiveRefreshInterval = 7 * 24 * time.Hour
+	// Number of recent episodes used to measure the release cadence.
+	cadenceSampleSize = 10
+	// Fraction of the typical gap between episodes to wait between checks.
+	cadenceDivisor = 8
+)
+
+// checkFrequency is the interval of the refresh job, which is also the
+// shortest interval a feed can be refreshed at.
+func checkFrequency() time.Duration {
+	return time.Duration(getPositiveIntEnv("CHECK_FREQUENCY", 30)) * time.Minute
+}
+
+// computeRefreshInterval derives how often a feed should be checked from the
+// typical gap between its most recent episodes.
+func computeRefreshInterval(feed *model.Feed) time.Duration {
+	var dates []time.Time
+	for _, item := range feed.Items {
+		if pubDate, ok := ParsePubDate(item.PubDate); ok {
+			dates = append(dates, pubDate)
+		}
+	}
+	if len(dates) < 2 {
+		return checkFrequency()
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].After(dates[j]) })
+	if len(dates) > cadenceSampleSize {
+		dates = dates[:cadenceSampleSize]
+	}
+
+	var gaps []time.Duration
+	for i := 1; i < len(dates); i++ {
+		if gap := dates[i-1].Sub(dates[i]); gap > 0 {
+			gaps = append(gaps, gap)
+		}
+	}
+	if len(gaps) == 0 {
+		return checkFrequency()
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+
+	interval := gaps[len(gaps)/2] / cadenceDivisor
+	if interval < checkFrequency() {
+		interval = checkFrequency()
+	}
+	if interval > maxRefreshInterval {
+		interval = maxRefreshInterval
+	}
+	return interval
+}
+
+// GetRefreshInterval returns the interval the podcast is refreshed at: the
+// manual override when set, otherwise the interval derived from its cadence,
+// stretched for complete and inactive shows.
+func GetRefreshInterval(podcast *db.Podcast) time.Duration {
+	if podcast.RefreshIntervalOverride > 0 {
+		return time.Duration(podcast.RefreshIntervalOverride) * time.Minute
+	}
+	if podcast.IsComplete {
+		return inactiveRefreshInterval
+	}
+	if podcast.LastEpisode != nil && time.Since(*podcast.LastEpisode) > inactiveAfter {
+		return inactiveRefreshInterval
+	}
+	if podcast.RefreshInterval > 0 {
+		return time.Duration(podcast.RefreshInterval) * time.Minute
+	}
+	return checkFrequency()
+}
+
+// feedRetryDelay is how long to wait before fetching a feed again after the
+// given number of consecutive failures. It doubles with every failure.
+func feedRetryDelay(failures int) time.Duration {
+	delay := feedBackoffBase
+	for i := 1; i < failures && delay < feedBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > feedBackoffMax {
+		delay = feedBackoffMax
+	}
+	return delay
+}
+
+func nextRefreshDate(podcast *db.Podcast) *time.Time {
+	if podcast.LastFetchDate == nil {
+		return nil
+	}
+	delay := GetRefreshInterval(podcast)
+	if podcast.ConsecutiveFailures > 0 {
+		delay = feedRetryDelay(podcast.ConsecutiveFailures)
+	}
+	next := podcast.LastFetchDate.Add(delay)
+	return &next
+}
+
+// isPodcastDue reports whether the podcast should be fetched by the current
+// run of the refresh job. A minute of slack keeps podcasts due at the next
+// run from being skipped because the job started slightly early.
+func isPodcastDue(podcast *db.Podcast) bool {
+	if podcast.NextRefreshDate == nil {
+		return true
+	}
+	return !time.Now().Add(time.Minute).Before(*podcast.NextRefreshDate)
+}
+
+func recordFeedHealth(podcast *db.Podcast, statusCode int, fetchErr error) {
+	now := time.Now()
+	podcast.LastFetchDate = &now
+	podcast.LastFetchStatus = statusCode
+	if fetchErr != nil {
+		podcast.LastFetchError = fetchErr.Error()
+		podcast.ConsecutiveFailures++
+	} else {
+		podcast.LastFetchError = ""
+		podcast.ConsecutiveFailures = 0
+		podcast.LastSuccessDate = &now
+	}
+	podcast.NextRefreshDate = nextRefreshDate(podcast)
+	err := db.UpdatePodcastFeedHealth(podcast)
+	if err != nil {
+		Logger.Errorw("Error updating feed health of "+podcast.Title, err)
+	}
+}
+
+// updateRefreshSchedule records what the latest version of the feed tells
+// about how often it should be refreshed.
+func updateRefreshSchedule(podcast *db.Podcast, feed *model.Feed) error {
+	podcast.IsComplete = feed.IsComplete
+	podcast.RefreshInterval = int(computeRefreshInterval(feed) / time.Minute)
+	return db.UpdatePodcastRefreshSchedule(podcast.ID, podcast.IsComplete, podcast.RefreshInterval)
+}
+
+// SetPodcastRefreshInterval sets the manual refresh interval of a podcast, in
+// minutes. Zero goes back to the interval derived from the feed.
+func SetPodcastRefreshInterval(id string, minutes int) error {
+	if minutes < 0 {
+		return fmt.Errorf("invalid refresh interval: %d", minutes)
+	}
+	var podcast db.Podcast
+	err := db.GetPodcastById(id, &podcast)
+	if err != nil {
+		return err
+	}
+	podcast.RefreshIntervalOverride = minutes
+	return db.UpdatePodcastRefreshIntervalOverride(podcast.ID, minutes, nextRefreshDate(&podcast))
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/akhilrex/podgrab/model"
+)
+
+// feedReleasedAt returns a feed with an episode published at each of the
+// given offsets before a fixed date.
+func feedReleasedAt(offsets ...time.Duration) *model.Feed {
+	latest := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	feed := &model.Feed{}
+	for _, offset := range offsets {
+		feed.Items = append(feed.Items, model.FeedItem{PubDate: latest.Add(-offset).Format(time.RFC1123Z)})
+	}
+	return feed
+}
+
+// every returns count offsets spaced by gap, starting from start.
+func every(start time.Duration, gap time.Duration, count int) []time.Duration {
+	var offsets []time.Duration
+	for i := 0; i < count; i++ {
+		offsets = append(offsets, start+time.Duration(i)*gap)
+	}
+	return offsets
+}
+
+func TestComputeRefreshInterval(t *testing.T) {
+	t.Setenv("CHECK_FREQUENCY", "30")
+
+	day := 24 * time.Hour
+	tests := []struct {
+		name string
+		feed *model.Feed
+		want time.Duration
+	}{
+		{"no episodes", &model.Feed{}, 30 * time.Minute},
+		{"single episode", feedReleasedAt(0), 30 * time.Minute},
+		{"undated episodes", &model.Feed{Items: []model.FeedItem{{PubDate: "soon"}, {PubDate: ""}}}, 30 * time.Minute},
+		{"same date", feedReleasedAt(0, 0, 0), 30 * time.Minute},
+		{"daily", feedReleasedAt(every(0, day, 5)...), 3 * time.Hour},
+		{"weekly", feedReleasedAt(every(0, 7*day, 5)...), 21 * time.Hour},
+		{"monthly", feedReleasedAt(every(0, 30*day, 5)...), 24 * time.Hour},
+		{"hourly", feedReleasedAt(every(0, time.Hour, 5)...), 30 * time.Minute},
+		{"unordered", feedReleasedAt(2*day, 0, 3*day, day), 3 * time.Hour},
+		{"median gap", feedReleasedAt(0, day, 2*day, 9*day), 3 * time.Hour},
+		{"recent episodes only", feedReleasedAt(append(every(0, day, 10), every(100*day, 30*day, 10)...)...), 3 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := computeRefreshInterval(test.feed); got != test.want {
+			t.Errorf("%s: computeRefreshInterval() = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	t.Setenv("CHECK_FREQUENCY", "60")
+	if got := computeRefreshInterval(feedReleasedAt(every(0, time.Hour, 5)...)); got != time.Hour {
+		t.Errorf("computeRefreshInterval() = %v, want the check frequency of %v", got, time.Hour)
+	}
+}
+
+func TestFeedRetryDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 30 * time.Minute},
+		{1, 30 * time.Minute},
+		{2, time.Hour},
+		{3, 2 * time.Hour},
+		{4, 4 * time.Hour},
+		{6, 16 * time.Hour},
+		{7, 24 * time.Hour},
+		{100, 24 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := feedRetryDelay(test.failures); got != test.want {
+			t.Errorf("feedRetryDelay(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/model"
+	pkgErrors "github.com/pkg/errors"
+)
+
+var transcriptExtensions = map[string]string{
+	"text/vtt":             ".vtt",
+	"application/x-subrip": ".srt",
+	"application/srt":      ".srt",
+	"text/srt":             ".srt",
+	"application/json":     ".json",
+	"text/html":            ".html",
+	"text/plain":           ".txt",
+}
+
+// Types transcripts are served with. Any other type, like HTML which would
+// run scripts from the origin of Podgrab, is served as plain text.
+var servedTranscriptTypes = map[string]bool{
+	"text/vtt":             true,
+	"application/x-subrip": true,
+	"application/srt":      true,
+	"application/json":     true,
+	"text/plain":           true,
+}
+
+// GetTranscriptContentType returns the Content-Type to serve a downloaded
+// transcript with, given the type declared by the feed.
+func GetTranscriptContentType(transcriptType string) string {
+	mediaType, _, err := mime.ParseMediaType(transcriptType)
+	if err != nil || !servedTranscriptTypes[mediaType] {
+		return "text/plain; charset=utf-8"
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return mediaType + "; charset=utf-8"
+	}
+	return mediaType
+}
+
+func toTranscripts(feedTranscripts []model.FeedTranscript) []db.Transcript {
+	var transcripts []db.Transcript
+	for _, feedTranscript := range feedTranscripts {
+		if feedTranscript.URL == "" {
+			continue
+		}
+		transcripts = append(transcripts, db.Transcript{
+			URL:      feedTranscript.URL,
+			Type:     feedTranscript.Type,
+			Language: feedTranscript.Language,
+			Rel:      feedTranscript.Rel,
+		})
+	}
+	return transcripts
+}
+
+// addMissingTranscripts records the transcripts of the feed that are not yet
+// known for an existing episode.
+func addMissingTranscripts(podcastItem *db.PodcastItem, feedTranscripts []model.FeedTranscript) error {
+	existing := make(map[string]bool)
+	for _, transcript := range podcastItem.Transcripts {
+		existing[transcript.URL] = true
+	}
+
+	for _, transcript := range toTranscripts(feedTranscripts) {
+		if existing[transcript.URL] {
+			continue
+		}
+		transcript.PodcastItemID = podcastItem.ID
+		err := db.CreateTranscript(&transcript)
+		if err != nil {
+			return pkgErrors.Wrap(err, "failed to create transcript")
+		}
+	}
+	return nil
+}
+
+// downloadTranscriptsLocally saves the transcripts of a downloaded episode
+// next to its audio file.
+func downloadTranscriptsLocally(podcastItemId string) error {
+	var podcastItem db.PodcastItem
+	err := db.GetPodcastItemById(podcastItemId, &podcastItem)
+	if err != nil {
+		return err
+	}
+	if podcastItem.DownloadPath == "" {
+		return nil
+	}
+
+	for _, transcript := range podcastItem.Transcripts {
+		if transcript.DownloadPath != "" && FileExists(transcript.DownloadPath) {
+			continue
+		}
+		extension := getTranscriptExtension(&transcript)
+		if transcript.Language != "" {
+			extension = "." + cleanFileName(transcript.Language) + extension
+		}
+		path, err := DownloadTranscript(transcript.URL, podcastItem.DownloadPath, extension, getPodcastCredentials(&podcastItem.Podcast))
+		if err != nil {
+			Logger.Errorw("Error downloading transcript: "+RedactURL(transcript.URL), err)
+			continue
+		}
+		err = db.UpdateTranscriptDownloadPath(transcript.ID, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteTranscriptFiles(podcastItem *db.PodcastItem) {
+	for _, transcript := range podcastItem.Transcripts {
+		if transcript.DownloadPath == "" {
+			continue
+		}
+		DeleteFile(transcript.DownloadPath)
+		db.UpdateTranscriptDownloadPath(transcript.ID, "")
+	}
+}
+
+// GetPreferredTranscript returns the transcript matching the requested mime
+// type, or the first one when no type is requested.
+func GetPreferredTranscript(transcripts []db.Transcript, transcriptType string) *db.Transcript {
+	if len(transcripts) == 0 {
+		return nil
+	}
+	if transcriptType == "" {
+		return &transcripts[0]
+	}
+	for i, transcript := range transcripts {
+		if strings.EqualFold(transcript.Type, transcriptType) {
+			return &transcripts[i]
+		}
+	}
+	return nil
+}
+
+func getTranscriptExtension(transcript *db.Transcript) string {
+	mimeType := strings.ToLower(strings.TrimSpace(strings.Split(transcript.Type, ";")[0]))
+	if ext, ok := transcriptExtensions[mimeType]; ok {
+		return ext
+	}
+	return ".txt"
+}
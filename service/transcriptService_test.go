@@ -0,0 +1,116 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akhilrex/podgrab/db"
+)
+
+func TestGetTranscriptContentType(t *testing.T) {
+	tests := []struct {
+		transcriptType string
+		want           string
+	}{
+		{"text/vtt", "text/vtt; charset=utf-8"},
+		{"application/x-subrip", "application/x-subrip"},
+		{"application/srt", "application/srt"},
+		{"application/json", "application/json"},
+		{"application/json; charset=utf-8", "application/json"},
+		{"text/plain", "text/plain; charset=utf-8"},
+		{"TEXT/VTT", "text/vtt; charset=utf-8"},
+		{"text/html", "text/plain; charset=utf-8"},
+		{"application/xhtml+xml", "text/plain; charset=utf-8"},
+		{"image/svg+xml", "text/plain; charset=utf-8"},
+		{"", "text/plain; charset=utf-8"},
+		{"not a type", "text/plain; charset=utf-8"},
+	}
+	for _, test := range tests {
+		if got := GetTranscriptContentType(test.transcriptType); got != test.want {
+			t.Errorf("GetTranscriptContentType(%q) = %q, want %q", test.transcriptType, got, test.want)
+		}
+	}
+}
+
+func TestDownloadMissingTranscripts(t *testing.T) {
+	setupTestDB(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/en.vtt":
+			w.Write([]byte("WEBVTT"))
+		case "/huge.vtt":
+			io.CopyN(w, zeroReader{}, maxTranscriptSize+1)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	podcast := db.Podcast{Title: "Show", URL: server.URL}
+	if err := db.CreatePodcast(&podcast); err != nil {
+		t.Fatal(err)
+	}
+	folder := t.TempDir()
+	items := []db.PodcastItem{
+		{PodcastID: podcast.ID, GUID: "downloaded", DownloadStatus: db.Downloaded, DownloadPath: filepath.Join(folder, "downloaded.mp3")},
+		{PodcastID: podcast.ID, GUID: "not downloaded"},
+	}
+	for i := range items {
+		if err := db.CreatePodcastItem(&items[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transcripts := []db.Transcript{
+		{PodcastItemID: items[0].ID, URL: server.URL + "/en.vtt", Type: "text/vtt", Language: "en"},
+		{PodcastItemID: items[0].ID, URL: server.URL + "/huge.vtt", Type: "text/vtt", Language: "fr"},
+		{PodcastItemID: items[1].ID, URL: server.URL + "/en.vtt", Type: "text/vtt"},
+	}
+	for i := range transcripts {
+		if err := db.CreateTranscript(&transcripts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := DownloadMissingTranscripts(); err != nil {
+		t.Fatalf("DownloadMissingTranscripts() error = %v", err)
+	}
+
+	var item db.PodcastItem
+	if err := db.GetPodcastItemById(items[0].ID, &item); err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]string{}
+	for _, transcript := range item.Transcripts {
+		paths[transcript.Language] = transcript.DownloadPath
+	}
+	want := filepath.Join(folder, "downloaded.en.vtt")
+	if paths["en"] != want {
+		t.Errorf("transcript saved to %q, want %q", paths["en"], want)
+	}
+	if content, _ := os.ReadFile(want); string(content) != "WEBVTT" {
+		t.Errorf("saved transcript %q, want %q", content, "WEBVTT")
+	}
+	// A transcript over the size limit is not kept, not even in part.
+	if paths["fr"] != "" || FileExists(filepath.Join(folder, "downloaded.fr.vtt")) {
+		t.Errorf("transcript over the size limit was saved to %q", paths["fr"])
+	}
+
+	var notDownloaded db.PodcastItem
+	if err := db.GetPodcastItemById(items[1].ID, &notDownloaded); err != nil {
+		t.Fatal(err)
+	}
+	if notDownloaded.Transcripts[0].DownloadPath != "" {
+		t.Errorf("transcript of an episode not downloaded saved to %q", notDownloaded.Transcripts[0].DownloadPath)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
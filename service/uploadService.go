@@ -0,0 +1,233 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/internal/id3"
+	"github.com/akhilrex/podgrab/internal/sanitize"
+	strip "github.com/grokify/html-strip-tags-go"
+	pkgErrors "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// Prefix of the GUIDs of uploaded episodes.
+const uploadGUIDPrefix = "upload:"
+
+// EpisodeUpload describes a media file uploaded as an episode. It goes to the
+// custom podcast PodcastID or else to the one titled PodcastTitle, which is
+// created when missing. Empty metadata is taken from the ID3 tag of the file,
+// its name and the current date.
+type EpisodeUpload struct {
+	PodcastID    string
+	PodcastTitle string
+	Title        string
+	PubDate      string
+	Notes        string
+	FileName     string
+	ContentType  string
+}
+
+// UploadEpisode stores an uploaded file in the folder of its podcast, as
+// Download does, and adds it as a downloaded episode.
+func UploadEpisode(upload EpisodeUpload, content io.Reader) (db.PodcastItem, error) {
+	mediaType := uploadMediaType(upload.FileName, upload.ContentType)
+	if !strings.HasPrefix(mediaType, "audio/") && !strings.HasPrefix(mediaType, "video/") {
+		return db.PodcastItem{}, errors.New("only audio and video files can be uploaded")
+	}
+
+	pubDate := time.Now()
+	if strings.TrimSpace(upload.PubDate) != "" {
+		parsed, ok := ParsePubDate(upload.PubDate)
+		if !ok {
+			return db.PodcastItem{}, fmt.Errorf("invalid date: %s", upload.PubDate)
+		}
+		pubDate = parsed
+	}
+
+	podcast, err := getUploadPodcast(upload.PodcastID, upload.PodcastTitle)
+	if err != nil {
+		return db.PodcastItem{}, err
+	}
+
+	folder, err := createDataFolderIfNotExists(podcast.Title)
+	if err != nil {
+		return db.PodcastItem{}, pkgErrors.Wrap(err, "failed to create data folder")
+	}
+	tempPath := path.Join(folder, ".upload-"+uuid.NewV4().String())
+	err = saveUpload(tempPath, content)
+	if err != nil {
+		return db.PodcastItem{}, err
+	}
+	err = checkMediaFile(tempPath)
+	if err != nil {
+		os.Remove(tempPath)
+		return db.PodcastItem{}, err
+	}
+
+	title := strings.TrimSpace(upload.Title)
+	var duration int
+	if tag, err := id3.ReadFile(tempPath); err == nil {
+		if title == "" {
+			title = tag.Title
+		}
+		duration = int(tag.Length.Seconds())
+	}
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(upload.FileName), filepath.Ext(upload.FileName))
+	}
+
+	var showNotes string
+	if strings.TrimSpace(upload.Notes) != "" {
+		showNotes, err = sanitize.HTMLAllowing(upload.Notes, showNotesTags, showNotesAttributes)
+		if err != nil {
+			os.Remove(tempPath)
+			return db.PodcastItem{}, pkgErrors.Wrap(err, "failed to sanitize notes")
+		}
+	}
+
+	podcastItem := db.PodcastItem{
+		PodcastID:      podcast.ID,
+		Title:          title,
+		Summary:        strings.TrimSpace(strip.StripTags(upload.Notes)),
+		ShowNotes:      strings.TrimSpace(showNotes),
+		EpisodeType:    "full",
+		Duration:       duration,
+		PubDate:        pubDate,
+		GUID:           uploadGUIDPrefix + uuid.NewV4().String(),
+		EnclosureType:  mediaType,
+		DownloadStatus: db.Downloading,
+	}
+	err = db.CreatePodcastItem(&podcastItem)
+	if err != nil {
+		os.Remove(tempPath)
+		return db.PodcastItem{}, pkgErrors.Wrap(err, "failed to create podcast item")
+	}
+
+	finalPath, err := uploadFilePath(folder, &podcastItem, upload.FileName)
+	if err == nil {
+		err = os.Rename(tempPath, finalPath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		db.DeletePodcastItemById(podcastItem.ID)
+		return db.PodcastItem{}, pkgErrors.Wrap(err, "failed to store upload")
+	}
+	err = changeOwnership(finalPath)
+	if err != nil {
+		os.Remove(finalPath)
+		db.DeletePodcastItemById(podcastItem.ID)
+		return db.PodcastItem{}, pkgErrors.Wrap(err, "failed to change ownership")
+	}
+
+	err = SetPodcastItemAsDownloaded(podcastItem.ID, finalPath)
+	if err != nil {
+		return db.PodcastItem{}, err
+	}
+	readChaptersFromFile(podcastItem.ID)
+	if podcast.LastEpisode == nil || pubDate.After(*podcast.LastEpisode) {
+		db.UpdateLastEpisodeDateForPodcast(podcast.ID, pubDate)
+	}
+
+	var created db.PodcastItem
+	err = db.GetPodcastItemById(podcastItem.ID, &created)
+	return created, err
+}
+
+// getUploadPodcast returns the custom podcast an upload goes to, creating it
+// when it is given by a title no custom podcast has.
+func getUploadPodcast(podcastId string, podcastTitle string) (*db.Podcast, error) {
+	var podcast db.Podcast
+	if podcastId != "" {
+		err := db.GetPodcastById(podcastId, &podcast)
+		if err != nil {
+			return nil, err
+		}
+		if !podcast.IsCustom {
+			return nil, errors.New("episodes can only be uploaded to custom podcasts")
+		}
+		return &podcast, nil
+	}
+
+	podcastTitle = strings.TrimSpace(podcastTitle)
+	if podcastTitle == "" {
+		return nil, errors.New("a podcast or a podcast title is required")
+	}
+	err := db.GetCustomPodcastByTitle(podcastTitle, &podcast)
+	if err == nil {
+		return &podcast, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	podcast = db.Podcast{
+		Title:    podcastTitle,
+		IsCustom: true,
+	}
+	err = db.CreatePodcast(&podcast)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "failed to create podcast")
+	}
+	setting := db.GetOrCreateSetting()
+	if setting.GenerateNFOFile {
+		go CreateNfoFile(&podcast)
+	}
+	return &podcast, nil
+}
+
+// uploadMediaType returns the media type of an upload, as sent by the client
+// when it is a media one, otherwise from the extension of its name.
+func uploadMediaType(fileName string, contentType string) string {
+	if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+		if strings.HasPrefix(parsed, "audio/") || strings.HasPrefix(parsed, "video/") {
+			return parsed
+		}
+	}
+	return mediaTypeByExtension("upload" + filepath.Ext(fileName))
+}
+
+func saveUpload(filePath string, content io.Reader) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to create file")
+	}
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filePath)
+		return pkgErrors.Wrap(err, "failed to save file")
+	}
+	return nil
+}
+
+// uploadFilePath names the file of an uploaded episode like a downloaded one,
+// numbering it when the name is taken.
+func uploadFilePath(folder string, podcastItem *db.PodcastItem, uploadName string) (string, error) {
+	fileName, err := generateMediaFileName("upload"+strings.ToLower(filepath.Ext(uploadName)), podcastItem.Title, "", podcastItem.EnclosureType)
+	if err != nil {
+		return "", err
+	}
+	if prefix := GetPodcastPrefix(podcastItem, db.GetOrCreateSetting()); prefix != "" {
+		fileName = fmt.Sprintf("%s-%s", prefix, fileName)
+	}
+
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	finalPath := path.Join(folder, fileName)
+	for i := 2; FileExists(finalPath); i++ {
+		finalPath = path.Join(folder, fmt.Sprintf("%s-%d%s", base, i, ext))
+	}
+	return finalPath, nil
+}
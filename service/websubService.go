@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/model"
+	pkgErrors "github.com/pkg/errors"
+)
+
+const (
+	webSubLeaseSeconds = 7 * 24 * 60 * 60
+	// Subscriptions are renewed when their lease expires within this window.
+	webSubRenewBefore = 24 * time.Hour
+	// Subscriptions the hub did not verify are asked for again after
+	// webSubRetryDelay, the ones it denied after webSubDeniedRetryDelay. The
+	// podcast is polled in the meantime.
+	webSubRetryDelay       = 24 * time.Hour
+	webSubDeniedRetryDelay = 7 * 24 * time.Hour
+)
+
+// webSubTransport sends the requests to hubs.
+var webSubTransport http.RoundTripper = http.DefaultTransport
+
+// refreshPushedPodcast fetches the feed of a podcast its hub notified.
+var refreshPushedPodcast = func(podcast *db.Podcast) {
+	go getFeedPool().Run(context.Background(), []feedTask{{URL: podcast.URL, Fetch: func(ctx context.Context) {
+		err := AddPodcastItems(ctx, podcast, false)
+		if err != nil {
+			Logger.Errorw("Error refreshing pushed podcast "+podcast.Title, err)
+			return
+		}
+		go DownloadMissingEpisodes()
+	}}})
+}
+
+// ErrWebSubNotVerified is returned when a verification request of a hub does
+// not match a subscription Podgrab asked for.
+var ErrWebSubNotVerified = errors.New("websub: subscription not requested")
+
+// ErrWebSubNotAuthenticated is returned for notifications which are not
+// signed with the secret of an active subscription.
+var ErrWebSubNotAuthenticated = errors.New("websub: notification not authenticated")
+
+// webSubCallbackURL returns the URL hubs should call back for the podcast.
+// WebSub is disabled, and polling alone is used, when PUBLIC_URL is not set
+// as hubs need to reach Podgrab.
+func webSubCallbackURL(podcastId string) string {
+	publicURL := strings.TrimRight(strings.TrimSpace(os.Getenv("PUBLIC_URL")), "/")
+	if publicURL == "" {
+		return ""
+	}
+	return publicURL + "/websub/" + podcastId
+}
+
+// updateWebSubHub keeps the subscription of a podcast in line with the hub
+// its feed advertises, subscribing to new hubs and leaving old ones.
+func updateWebSubHub(podcast *db.Podcast, feed *model.Feed) error {
+	hubURL := feed.HubURL
+	if !isSecureWebSubHub(hubURL) {
+		// The secret notifications are signed with can only be sent over
+		// https, such hubs are left to polling.
+		hubURL = ""
+	}
+	topic := feed.SelfURL
+	if topic == "" {
+		topic = podcast.URL
+	}
+	if hubURL == "" {
+		topic = ""
+	}
+	if hubURL == podcast.HubURL && topic == podcast.HubTopic {
+		return nil
+	}
+
+	if podcast.HubURL != "" {
+		old := *podcast
+		go func() {
+			err := requestWebSubSubscription(&old, "unsubscribe")
+			if err != nil {
+				Logger.Errorw("Error unsubscribing from hub "+old.HubURL, err)
+			}
+		}()
+	}
+
+	secret := ""
+	if hubURL != "" {
+		var err error
+		secret, err = newWebSubSecret()
+		if err != nil {
+			return err
+		}
+	}
+	err := db.UpdatePodcastHub(podcast.ID, hubURL, topic, secret)
+	if err != nil {
+		return err
+	}
+	podcast.HubURL, podcast.HubTopic, podcast.HubSecret, podcast.HubLeaseExpiry = hubURL, topic, secret, nil
+
+	if hubURL != "" {
+		subscription := *podcast
+		go func() {
+			err := requestWebSubSubscription(&subscription, "subscribe")
+			if err != nil {
+				Logger.Errorw("Error subscribing to hub "+subscription.HubURL, err)
+			}
+		}()
+	}
+	return nil
+}
+
+func isSecureWebSubHub(hubURL string) bool {
+	parsed, err := url.Parse(hubURL)
+	return err == nil && parsed.Scheme == "https" && parsed.Host != ""
+}
+
+func newWebSubSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", pkgErrors.Wrap(err, "failed to generate websub secret")
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// requestWebSubSubscription asks the hub of the podcast to subscribe or
+// unsubscribe. The hub confirms asynchronously through the callback.
+func requestWebSubSubscription(podcast *db.Podcast, mode string) error {
+	callback := webSubCallbackURL(podcast.ID)
+	if callback == "" || podcast.HubURL == "" {
+		return nil
+	}
+
+	form := url.Values{}
+	form.Set("hub.callback", callback)
+	form.Set("hub.mode", mode)
+	form.Set("hub.topic", podcast.HubTopic)
+	if mode == "subscribe" {
+		// Without a secret sent over https notifications cannot be
+		// authenticated, so there is no point subscribing.
+		if podcast.HubSecret == "" || !isSecureWebSubHub(podcast.HubURL) {
+			return nil
+		}
+		form.Set("hub.lease_seconds", strconv.Itoa(webSubLeaseSeconds))
+		form.Set("hub.secret", podcast.HubSecret)
+
+		// Until the hub verifies the subscription, it is not asked for
+		// again before the retry date.
+		retryDate := time.Now().Add(webSubRetryDelay)
+		err := db.UpdatePodcastHubRetryDate(podcast.ID, &retryDate)
+		if err != nil {
+			return pkgErrors.Wrap(err, "failed to update websub retry date")
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, podcast.HubURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to create hub request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: feedFetchTimeout(), Transport: webSubTransport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("hub refused %s request: %s", mode, resp.Status)
+	}
+	fmt.Println("Requested websub " + mode + " for " + RedactURL(podcast.HubTopic) + " at " + podcast.HubURL)
+	return nil
+}
+
+// VerifyWebSubSubscription answers the verification of intent of a hub. It
+// returns the challenge to echo back when the request matches what was asked.
+func VerifyWebSubSubscription(podcastId, mode, topic, challenge, leaseSeconds string) (string, error) {
+	var podcast db.Podcast
+	err := db.GetPodcastById(podcastId, &podcast)
+	subscribed := err == nil && podcast.HubURL != "" && podcast.HubTopic == topic
+
+	switch mode {
+	case "subscribe":
+		if !subscribed {
+			return "", ErrWebSubNotVerified
+		}
+		lease, err := strconv.Atoi(leaseSeconds)
+		if err != nil || lease <= 0 {
+			lease = webSubLeaseSeconds
+		}
+		expiry := time.Now().Add(time.Duration(lease) * time.Second)
+		err = db.UpdatePodcastHubLeaseExpiry(podcast.ID, &expiry)
+		if err == nil {
+			err = db.UpdatePodcastHubRetryDate(podcast.ID, nil)
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Println("Websub subscription verified for " + RedactURL(topic))
+		return challenge, nil
+	case "unsubscribe":
+		// Only confirm leaving topics which are not wanted anymore.
+		if subscribed {
+			return "", ErrWebSubNotVerified
+		}
+		return challenge, nil
+	case "denied":
+		if subscribed {
+			fmt.Println("Websub subscription denied for " + RedactURL(topic))
+			err := db.UpdatePodcastHubLeaseExpiry(podcast.ID, nil)
+			if err != nil {
+				return "", err
+			}
+			retryDate := time.Now().Add(webSubDeniedRetryDelay)
+			return "", db.UpdatePodcastHubRetryDate(podcast.ID, &retryDate)
+		}
+		return "", nil
+	}
+	return "", ErrWebSubNotVerified
+}
+
+// HandleWebSubNotification refreshes the podcast a hub pushed new content
+// for. Notifications are rejected with ErrWebSubNotAuthenticated unless the
+// podcast has a subscription the hub verified, whose lease has not expired,
+// and they are signed with its secret.
+func HandleWebSubNotification(podcastId string, body []byte, signature string) error {
+	var podcast db.Podcast
+	err := db.GetPodcastById(podcastId, &podcast)
+	if err != nil {
+		return err
+	}
+	verified := podcast.HubLeaseExpiry != nil && podcast.HubLeaseExpiry.After(time.Now())
+	if podcast.HubURL == "" || podcast.HubSecret == "" || !verified || !isValidWebSubSignature(podcast.HubSecret, body, signature) {
+		return fmt.Errorf("%w for %s", ErrWebSubNotAuthenticated, podcast.Title)
+	}
+
+	fmt.Println("Websub notification for " + podcast.Title)
+	refreshPushedPodcast(&podcast)
+	return nil
+}
+
+func isValidWebSubSignature(secret string, body []byte, signature string) bool {
+	method, value, found := strings.Cut(signature, "=")
+	if !found {
+		return false
+	}
+	var newHash func() hash.Hash
+	switch strings.ToLower(method) {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha384":
+		newHash = sha512.New384
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+	expected, err := hex.DecodeString(value)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// RenewWebSubSubscriptions renews the subscriptions whose lease is about to
+// expire, and retries the ones the hub did not verify or denied once their
+// retry date has passed.
+func RenewWebSubSubscriptions() error {
+	if webSubCallbackURL("") == "" {
+		return nil
+	}
+	podcasts, err := db.GetPodcastsWithHubLeaseExpiringBefore(time.Now().Add(webSubRenewBefore))
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to get podcasts with expiring websub lease")
+	}
+	for _, podcast := range *podcasts {
+		err := requestWebSubSubscription(&podcast, "subscribe")
+		if err != nil {
+			Logger.Errorw("Error renewing websub subscription of "+podcast.Title, err)
+		}
+	}
+	return nil
+}
+
+// unsubscribeWebSub leaves the hub of a podcast which is being deleted.
+func unsubscribeWebSub(podcast *db.Podcast) {
+	if podcast.HubURL == "" {
+		return
+	}
+	err := requestWebSubSubscription(podcast, "unsubscribe")
+	if err != nil {
+		Logger.Errorw("Error unsubscribing from hub "+podcast.HubURL, err)
+	}
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akhilrex/podgrab/db"
+	"github.com/akhilrex/podgrab/model"
+)
+
+func sign(newHash func() hash.Hash, secret string, body string) string {
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestIsValidWebSubSignature(t *testing.T) {
+	const secret = "secret"
+	const body = "<rss></rss>"
+	tests := []struct {
+		name      string
+		signature string
+		want      bool
+	}{
+		{"sha1", "sha1=" + sign(sha1.New, secret, body), true},
+		{"sha256", "sha256=" + sign(sha256.New, secret, body), true},
+		{"uppercase method", "SHA256=" + sign(sha256.New, secret, body), true},
+		{"other secret", "sha256=" + sign(sha256.New, "other", body), false},
+		{"other body", "sha256=" + sign(sha256.New, secret, "<rss/>"), false},
+		{"method mismatch", "sha1=" + sign(sha256.New, secret, body), false},
+		{"unknown method", "md5=" + sign(sha256.New, secret, body), false},
+		{"not hex", "sha256=zz", false},
+		{"no method", sign(sha256.New, secret, body), false},
+		{"missing", "", false},
+	}
+	for _, test := range tests {
+		if got := isValidWebSubSignature(secret, []byte(body), test.signature); got != test.want {
+			t.Errorf("%s: isValidWebSubSignature = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestIsSecureWebSubHub(t *testing.T) {
+	tests := []struct {
+		hubURL string
+		want   bool
+	}{
+		{"https://pubsubhubbub.appspot.com/", true},
+		{"http://pubsubhubbub.appspot.com/", false},
+		{"https://", false},
+		{"", false},
+		{"pubsubhubbub.appspot.com", false},
+	}
+	for _, test := range tests {
+		if got := isSecureWebSubHub(test.hubURL); got != test.want {
+			t.Errorf("isSecureWebSubHub(%q) = %v, want %v", test.hubURL, got, test.want)
+		}
+	}
+}
+
+// TestWebSubSubscription runs a subscription against a local stand-in of a
+// hub: the hub is asked to subscribe, verifies the intent through the
+// callback, then pushes notifications which are only accepted when signed.
+func TestWebSubSubscription(t *testing.T) {
+	setupTestDB(t)
+
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		podcastId := strings.TrimPrefix(r.URL.Path, "/websub/")
+		if r.Method == http.MethodGet {
+			query := r.URL.Query()
+			challenge, err := VerifyWebSubSubscription(podcastId, query.Get("hub.mode"), query.Get("hub.topic"), query.Get("hub.challenge"), query.Get("hub.lease_seconds"))
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(challenge))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		err := HandleWebSubNotification(podcastId, body, r.Header.Get("X-Hub-Signature"))
+		if errors.Is(err, ErrWebSubNotAuthenticated) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer callback.Close()
+	t.Setenv("PUBLIC_URL", callback.URL)
+
+	requests := make(chan url.Values, 10)
+	hub := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests <- r.PostForm
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	defaultTransport, defaultRefresh := webSubTransport, refreshPushedPodcast
+	t.Cleanup(func() { webSubTransport, refreshPushedPodcast = defaultTransport, defaultRefresh })
+	webSubTransport = hub.Client().Transport
+	var refreshed atomic.Int32
+	refreshPushedPodcast = func(podcast *db.Podcast) { refreshed.Add(1) }
+
+	const topic = "https://example.com/feed.xml"
+	podcast := db.Podcast{Title: "Pushed", URL: topic}
+	if err := db.CreatePodcast(&podcast); err != nil {
+		t.Fatal(err)
+	}
+
+	nextRequest := func() url.Values {
+		t.Helper()
+		select {
+		case form := <-requests:
+			return form
+		case <-time.After(5 * time.Second):
+			t.Fatal("no request to the hub")
+			return nil
+		}
+	}
+	verify := func(mode string, topic string, challenge string) (int, string) {
+		t.Helper()
+		query := url.Values{"hub.mode": {mode}, "hub.topic": {topic}, "hub.challenge": {challenge}, "hub.lease_seconds": {"3600"}}
+		resp, err := http.Get(callback.URL + "/websub/" + podcast.ID + "?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	push := func(signature string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, callback.URL+"/websub/"+podcast.ID, strings.NewReader("<rss/>"))
+		req.Header.Set("X-Hub-Signature", signature)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if err := updateWebSubHub(&podcast, &model.Feed{HubURL: hub.URL, SelfURL: topic}); err != nil {
+		t.Fatal(err)
+	}
+	form := nextRequest()
+	secret := form.Get("hub.secret")
+	if form.Get("hub.mode") != "subscribe" || form.Get("hub.topic") != topic || form.Get("hub.callback") != callback.URL+"/websub/"+podcast.ID || len(secret) != 64 {
+		t.Fatalf("subscription request %v", form)
+	}
+	signed := "sha256=" + sign(sha256.New, secret, "<rss/>")
+
+	if status := push(signed); status != http.StatusForbidden {
+		t.Errorf("push before verification: status %d, want %d", status, http.StatusForbidden)
+	}
+	if status, _ := verify("subscribe", "https://example.com/other.xml", "challenge"); status != http.StatusNotFound {
+		t.Errorf("verification of another topic: status %d, want %d", status, http.StatusNotFound)
+	}
+	if status, body := verify("subscribe", topic, "challenge"); status != http.StatusOK || body != "challenge" {
+		t.Fatalf("verification: status %d with %q, want the challenge echoed", status, body)
+	}
+
+	if status := push(signed); status != http.StatusAccepted {
+		t.Errorf("signed push: status %d, want %d", status, http.StatusAccepted)
+	}
+	for _, signature := range []string{"", "sha256=" + sign(sha256.New, "other", "<rss/>"), "sha256=" + sign(sha256.New, secret, "<rss></rss>")} {
+		if status := push(signature); status != http.StatusForbidden {
+			t.Errorf("push signed with %q: status %d, want %d", signature, status, http.StatusForbidden)
+		}
+	}
+	if count := refreshed.Load(); count != 1 {
+		t.Errorf("refreshed %d times, want once", count)
+	}
+
+	// The lease of an hour expires within the renewal window.
+	if err := RenewWebSubSubscriptions(); err != nil {
+		t.Fatal(err)
+	}
+	if form := nextRequest(); form.Get("hub.mode") != "subscribe" || form.Get("hub.secret") != secret {
+		t.Errorf("renewal request %v", form)
+	}
+
+	// Once denied, pushes are rejected and the hub is left alone.
+	if status, _ := verify("denied", topic, ""); status != http.StatusOK {
+		t.Errorf("denial: status %d, want %d", status, http.StatusOK)
+	}
+	if status := push(signed); status != http.StatusForbidden {
+		t.Errorf("push after denial: status %d, want %d", status, http.StatusForbidden)
+	}
+	if err := RenewWebSubSubscriptions(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case form := <-requests:
+		t.Errorf("subscribed again right after a denial: %v", form)
+	default:
+	}
+}